@@ -0,0 +1,549 @@
+// Package gmcgroup publishes per container metrics from the cgroup v1 and v2
+// hierarchies to ganglia. Each container is published as its own spoofed
+// host.
+package gmcgroup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// The default patterns used to find the containers.
+var defaultPatterns = []string{
+	"docker/*",
+	"system.slice/docker-*.scope",
+}
+
+// The cgroup v1 controllers. The cpuacct controller is usually co-mounted
+// with the cpu controller.
+var (
+	cpuControllers    = []string{"cpuacct", "cpu,cpuacct", "cpuacct,cpu"}
+	memoryControllers = []string{"memory"}
+	blkioControllers  = []string{"blkio"}
+	pidsControllers   = []string{"pids"}
+)
+
+// USER_HZ, the unit used by cpuacct.stat.
+const userHZ = 100
+
+// Memory limits at or above this value mean there is no limit in cgroup v1.
+const unlimited = 1 << 62
+
+// Collector is a gmetric.Collector that provides the CPU, memory, IO and pids
+// metrics for each container found in the cgroup hierarchy. The metrics of a
+// container are spoofed to a host derived from its cgroup path, and a
+// heartbeat is sent for each container so the spoofed hosts stay alive. The
+// rate based metrics are omitted on the first collection of a container.
+type Collector struct {
+	// The root of the cgroup filesystem. Defaults to /sys/fs/cgroup.
+	Root string
+
+	// Patterns for the container cgroups as understood by filepath.Match,
+	// relative to the root of the hierarchy for cgroup v2 and to the root of
+	// each controller for cgroup v1. Defaults to "docker/*" and
+	// "system.slice/docker-*.scope".
+	Patterns []string
+
+	// Spoof returns the spoof "IP:hostname" for the container with the given
+	// cgroup path. Defaults to using the container name for both, which is
+	// the base name of the path without the "docker-" prefix and ".scope"
+	// suffix, and shortened to 12 characters.
+	Spoof func(path string) string
+
+	mu         sync.Mutex
+	now        func() time.Time
+	containers map[string]*container
+}
+
+var _ gmetric.Collector = (*Collector)(nil)
+
+type container struct {
+	heartbeat  *gmetric.Metric
+	cpu        *gmetric.Metric
+	cpuUser    *gmetric.Metric
+	cpuSystem  *gmetric.Metric
+	memUsage   *gmetric.Metric
+	memLimit   *gmetric.Metric
+	readBytes  *gmetric.Metric
+	writeBytes *gmetric.Metric
+	readOps    *gmetric.Metric
+	writeOps   *gmetric.Metric
+	pids       *gmetric.Metric
+
+	last   *stats
+	lastAt time.Time
+}
+
+// The stats for a container. A nil field means it is not available.
+type stats struct {
+	cpu, cpuUser, cpuSystem                        *time.Duration
+	memUsage, memLimit                             *uint64
+	readBytes, writeBytes, readOps, writeOps, pids *uint64
+}
+
+func newContainer(spoof string) *container {
+	metric := func(name, title, units string) *gmetric.Metric {
+		return &gmetric.Metric{
+			Name:      name,
+			Title:     title,
+			Units:     units,
+			Spoof:     spoof,
+			Groups:    []string{"cgroup"},
+			ValueType: gmetric.ValueFloat64,
+			Slope:     gmetric.SlopeBoth,
+		}
+	}
+	c := &container{
+		heartbeat:  gmetric.HeartbeatMetric(spoof),
+		cpu:        metric("cgroup_cpu_usage", "CPU Usage", "%"),
+		cpuUser:    metric("cgroup_cpu_user", "CPU User", "%"),
+		cpuSystem:  metric("cgroup_cpu_system", "CPU System", "%"),
+		memUsage:   metric("cgroup_mem_usage", "Memory Usage", "bytes"),
+		memLimit:   metric("cgroup_mem_limit", "Memory Limit", "bytes"),
+		readBytes:  metric("cgroup_io_read_bytes", "Bytes Read", "bytes/sec"),
+		writeBytes: metric("cgroup_io_write_bytes", "Bytes Written", "bytes/sec"),
+		readOps:    metric("cgroup_io_reads", "Reads", "reads/sec"),
+		writeOps:   metric("cgroup_io_writes", "Writes", "writes/sec"),
+		pids:       metric("cgroup_pids", "Processes", "processes"),
+	}
+	c.pids.ValueType = gmetric.ValueUint32
+	return c
+}
+
+// Metrics returns the metrics for the containers found so far.
+func (c *Collector) Metrics() []*gmetric.Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var metrics []*gmetric.Metric
+	for _, path := range c.sortedPaths() {
+		ct := c.containers[path]
+		metrics = append(metrics,
+			ct.cpu, ct.cpuUser, ct.cpuSystem, ct.memUsage, ct.memLimit,
+			ct.readBytes, ct.writeBytes, ct.readOps, ct.writeOps, ct.pids,
+		)
+	}
+	return metrics
+}
+
+// Collect finds the current containers and returns their samples. Containers
+// that have gone away are forgotten, their metrics expire based on the
+// Lifetime configured on the Client.
+func (c *Collector) Collect() ([]gmetric.Sample, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+
+	v2 := exists(c.join("cgroup.controllers"))
+	paths, err := c.find(v2)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*container)
+	var samples []gmetric.Sample
+	var errs gmetric.MultiError
+	for _, path := range paths {
+		ct := c.containers[path]
+		if ct == nil {
+			ct = newContainer(c.spoof(path))
+		}
+		found[path] = ct
+
+		at := now()
+		var s *stats
+		if v2 {
+			s, err = c.readV2(path)
+		} else {
+			s, err = c.readV1(path)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		samples = append(samples, ct.samples(s, at)...)
+	}
+	c.containers = found
+
+	if len(errs) == 0 {
+		return samples, nil
+	}
+	return samples, errs
+}
+
+func (ct *container) samples(s *stats, at time.Time) []gmetric.Sample {
+	samples := []gmetric.Sample{{Metric: ct.heartbeat, Value: 0}}
+	gauges := []struct {
+		metric *gmetric.Metric
+		value  *uint64
+	}{
+		{ct.memUsage, s.memUsage},
+		{ct.memLimit, s.memLimit},
+		{ct.pids, s.pids},
+	}
+	for _, g := range gauges {
+		if g.value != nil {
+			samples = append(samples, gmetric.Sample{Metric: g.metric, Value: *g.value})
+		}
+	}
+
+	last, lastAt := ct.last, ct.lastAt
+	ct.last, ct.lastAt = s, at
+	if last == nil {
+		return samples
+	}
+	elapsed := at.Sub(lastAt)
+	if elapsed <= 0 {
+		return samples
+	}
+
+	cpu := []struct {
+		metric    *gmetric.Metric
+		cur, prev *time.Duration
+	}{
+		{ct.cpu, s.cpu, last.cpu},
+		{ct.cpuUser, s.cpuUser, last.cpuUser},
+		{ct.cpuSystem, s.cpuSystem, last.cpuSystem},
+	}
+	for _, r := range cpu {
+		if r.cur == nil || r.prev == nil || *r.cur < *r.prev {
+			continue
+		}
+		samples = append(samples, gmetric.Sample{
+			Metric: r.metric,
+			Value:  float64(*r.cur-*r.prev) * 100 / float64(elapsed),
+		})
+	}
+
+	io := []struct {
+		metric    *gmetric.Metric
+		cur, prev *uint64
+	}{
+		{ct.readBytes, s.readBytes, last.readBytes},
+		{ct.writeBytes, s.writeBytes, last.writeBytes},
+		{ct.readOps, s.readOps, last.readOps},
+		{ct.writeOps, s.writeOps, last.writeOps},
+	}
+	for _, r := range io {
+		if r.cur == nil || r.prev == nil || *r.cur < *r.prev {
+			continue
+		}
+		samples = append(samples, gmetric.Sample{
+			Metric: r.metric,
+			Value:  float64(*r.cur-*r.prev) / elapsed.Seconds(),
+		})
+	}
+	return samples
+}
+
+func (c *Collector) sortedPaths() []string {
+	paths := make([]string, 0, len(c.containers))
+	for path := range c.containers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (c *Collector) join(elem ...string) string {
+	root := c.Root
+	if root == "" {
+		root = "/sys/fs/cgroup"
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// Returns the sorted container paths relative to the hierarchy root.
+func (c *Collector) find(v2 bool) ([]string, error) {
+	patterns := c.Patterns
+	if len(patterns) == 0 {
+		patterns = defaultPatterns
+	}
+
+	var roots []string
+	if v2 {
+		roots = []string{c.join()}
+	} else {
+		for _, controllers := range [][]string{cpuControllers, memoryControllers, blkioControllers, pidsControllers} {
+			for _, controller := range controllers {
+				roots = append(roots, c.join(controller))
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, root := range roots {
+		for _, pattern := range patterns {
+			matches, err := filepath.Glob(filepath.Join(root, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("gmcgroup: invalid pattern %q: %s", pattern, err)
+			}
+			for _, match := range matches {
+				if !isDir(match) {
+					continue
+				}
+				rel, err := filepath.Rel(root, match)
+				if err != nil {
+					return nil, err
+				}
+				if !seen[rel] {
+					seen[rel] = true
+					paths = append(paths, rel)
+				}
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (c *Collector) spoof(path string) string {
+	if c.Spoof != nil {
+		return c.Spoof(path)
+	}
+	name := filepath.Base(path)
+	name = strings.TrimPrefix(name, "docker-")
+	name = strings.TrimSuffix(name, ".scope")
+	if len(name) > 12 {
+		name = name[:12]
+	}
+	return name + ":" + name
+}
+
+func (c *Collector) readV2(path string) (*stats, error) {
+	dir := c.join(path)
+	s := &stats{}
+
+	if kv, err := readKeyValues(filepath.Join(dir, "cpu.stat")); err == nil {
+		usec := func(key string) *time.Duration {
+			if v, ok := kv[key]; ok {
+				d := time.Duration(v) * time.Microsecond
+				return &d
+			}
+			return nil
+		}
+		s.cpu = usec("usage_usec")
+		s.cpuUser = usec("user_usec")
+		s.cpuSystem = usec("system_usec")
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var err error
+	if s.memUsage, err = readUint(filepath.Join(dir, "memory.current")); err != nil {
+		return nil, err
+	}
+	if s.memLimit, err = readUint(filepath.Join(dir, "memory.max")); err != nil {
+		return nil, err
+	}
+	if s.pids, err = readUint(filepath.Join(dir, "pids.current")); err != nil {
+		return nil, err
+	}
+
+	lines, err := readLines(filepath.Join(dir, "io.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var rbytes, wbytes, rios, wios uint64
+		for _, f := range lines {
+			for _, field := range f[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				v, err := strconv.ParseUint(kv[1], 10, 64)
+				if err != nil {
+					continue
+				}
+				switch kv[0] {
+				case "rbytes":
+					rbytes += v
+				case "wbytes":
+					wbytes += v
+				case "rios":
+					rios += v
+				case "wios":
+					wios += v
+				}
+			}
+		}
+		s.readBytes, s.writeBytes, s.readOps, s.writeOps = &rbytes, &wbytes, &rios, &wios
+	}
+	return s, nil
+}
+
+func (c *Collector) readV1(path string) (*stats, error) {
+	s := &stats{}
+	var err error
+
+	if dir := c.controller(cpuControllers, path); dir != "" {
+		usage, err := readUint(filepath.Join(dir, "cpuacct.usage"))
+		if err != nil {
+			return nil, err
+		}
+		if usage != nil {
+			d := time.Duration(*usage)
+			s.cpu = &d
+		}
+		kv, err := readKeyValues(filepath.Join(dir, "cpuacct.stat"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		ticks := func(key string) *time.Duration {
+			if v, ok := kv[key]; ok {
+				d := time.Duration(v) * time.Second / userHZ
+				return &d
+			}
+			return nil
+		}
+		s.cpuUser = ticks("user")
+		s.cpuSystem = ticks("system")
+	}
+
+	if dir := c.controller(memoryControllers, path); dir != "" {
+		if s.memUsage, err = readUint(filepath.Join(dir, "memory.usage_in_bytes")); err != nil {
+			return nil, err
+		}
+		if s.memLimit, err = readUint(filepath.Join(dir, "memory.limit_in_bytes")); err != nil {
+			return nil, err
+		}
+		if s.memLimit != nil && *s.memLimit >= unlimited {
+			s.memLimit = nil
+		}
+	}
+
+	if dir := c.controller(blkioControllers, path); dir != "" {
+		if s.readBytes, s.writeBytes, err = readBlkio(filepath.Join(dir, "blkio.throttle.io_service_bytes")); err != nil {
+			return nil, err
+		}
+		if s.readOps, s.writeOps, err = readBlkio(filepath.Join(dir, "blkio.throttle.io_serviced")); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir := c.controller(pidsControllers, path); dir != "" {
+		if s.pids, err = readUint(filepath.Join(dir, "pids.current")); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Returns the directory for the container in the first of the given
+// controllers that has it, or an empty string.
+func (c *Collector) controller(controllers []string, path string) string {
+	for _, controller := range controllers {
+		if dir := c.join(controller, path); isDir(dir) {
+			return dir
+		}
+	}
+	return ""
+}
+
+func readBlkio(path string) (read, write *uint64, err error) {
+	lines, err := readLines(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	var r, w uint64
+	for _, f := range lines {
+		if len(f) != 3 {
+			continue
+		}
+		v, err := strconv.ParseUint(f[2], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gmcgroup: invalid %s: %s", path, err)
+		}
+		switch f[1] {
+		case "Read":
+			r += v
+		case "Write":
+			w += v
+		}
+	}
+	return &r, &w, nil
+}
+
+// Reads a file with a single number. Returns nil if the file does not exist
+// or contains "max".
+func readUint(path string) (*uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return nil, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("gmcgroup: invalid %s: %s", path, err)
+	}
+	return &v, nil
+}
+
+func readKeyValues(path string) (map[string]uint64, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	kv := make(map[string]uint64)
+	for _, f := range lines {
+		if len(f) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("gmcgroup: invalid %s: %s", path, err)
+		}
+		kv[f[0]] = v
+	}
+	return kv, nil
+}
+
+func readLines(path string) ([][]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if f := strings.Fields(s.Text()); len(f) > 0 {
+			lines = append(lines, f)
+		}
+	}
+	return lines, s.Err()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,179 @@
+package gmcgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+const containerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tempRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "gmcgroup_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// Returns the values keyed by "spoof/name".
+func collect(t *testing.T, c *Collector) map[string]interface{} {
+	samples, err := c.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	for _, s := range samples {
+		values[s.Metric.Spoof+"/"+s.Metric.Name] = s.Value
+	}
+	return values
+}
+
+func checkValue(t *testing.T, values map[string]interface{}, key string, expected interface{}) {
+	actual, ok := values[key]
+	if !ok {
+		t.Fatalf("missing value for %s in %v", key, values)
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("was expecting %v for %s but got %v", expected, key, actual)
+	}
+}
+
+func TestCollectV2(t *testing.T) {
+	t.Parallel()
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+
+	scope := "system.slice/docker-" + containerID + ".scope"
+	writeFiles(t, root, map[string]string{
+		"cgroup.controllers":                     "cpu io memory pids\n",
+		"system.slice/cron.service/pids.current": "1\n",
+		scope + "/cpu.stat":                      "usage_usec 1000000\nuser_usec 600000\nsystem_usec 400000\n",
+		scope + "/memory.current":                "1048576\n",
+		scope + "/memory.max":                    "max\n",
+		scope + "/pids.current":                  "4\n",
+		scope + "/io.stat":                       "8:0 rbytes=1000 wbytes=2000 rios=10 wios=20 dbytes=0 dios=0\n",
+		"docker/web/cpu.stat":                    "usage_usec 0\n",
+		"docker/web/memory.current":              "2048\n",
+		"docker/web/memory.max":                  "4096\n",
+	})
+
+	at := time.Unix(1500000000, 0)
+	c := &Collector{Root: root, now: func() time.Time { return at }}
+	v := collect(t, c)
+	host := "0123456789ab:0123456789ab"
+	checkValue(t, v, host+"/heartbeat", 0)
+	checkValue(t, v, host+"/cgroup_mem_usage", 1048576)
+	checkValue(t, v, host+"/cgroup_pids", 4)
+	checkValue(t, v, "web:web/cgroup_mem_limit", 4096)
+	if _, ok := v[host+"/cgroup_mem_limit"]; ok {
+		t.Fatal("was not expecting a limit for an unlimited container")
+	}
+	if _, ok := v[host+"/cgroup_cpu_usage"]; ok {
+		t.Fatal("was not expecting rates on the first collection")
+	}
+	if len(c.Metrics()) != 20 {
+		t.Fatalf("was expecting 20 metrics but got %d", len(c.Metrics()))
+	}
+
+	writeFiles(t, root, map[string]string{
+		scope + "/cpu.stat": "usage_usec 1500000\nuser_usec 900000\nsystem_usec 600000\n",
+		scope + "/io.stat":  "8:0 rbytes=3000 wbytes=2000 rios=30 wios=20\n8:16 rbytes=1000 wbytes=0 rios=10 wios=0\n",
+	})
+	at = at.Add(2 * time.Second)
+	v = collect(t, c)
+	checkValue(t, v, host+"/cgroup_cpu_usage", 25)
+	checkValue(t, v, host+"/cgroup_cpu_user", 15)
+	checkValue(t, v, host+"/cgroup_cpu_system", 10)
+	checkValue(t, v, host+"/cgroup_io_read_bytes", 1500)
+	checkValue(t, v, host+"/cgroup_io_write_bytes", 0)
+	checkValue(t, v, host+"/cgroup_io_reads", 15)
+	checkValue(t, v, host+"/cgroup_io_writes", 0)
+
+	// Containers that went away are forgotten.
+	if err := os.RemoveAll(filepath.Join(root, "docker")); err != nil {
+		t.Fatal(err)
+	}
+	v = collect(t, c)
+	if _, ok := v["web:web/heartbeat"]; ok {
+		t.Fatal("was not expecting a heartbeat for a removed container")
+	}
+	if len(c.Metrics()) != 10 {
+		t.Fatalf("was expecting 10 metrics but got %d", len(c.Metrics()))
+	}
+}
+
+func TestCollectV1(t *testing.T) {
+	t.Parallel()
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+
+	writeFiles(t, root, map[string]string{
+		"cpu,cpuacct/docker/web/cpuacct.usage":             "1000000000\n",
+		"cpu,cpuacct/docker/web/cpuacct.stat":              "user 60\nsystem 40\n",
+		"memory/docker/web/memory.usage_in_bytes":          "1048576\n",
+		"memory/docker/web/memory.limit_in_bytes":          "9223372036854771712\n",
+		"blkio/docker/web/blkio.throttle.io_service_bytes": "8:0 Read 100\n8:0 Write 200\n8:0 Sync 300\n8:0 Total 300\nTotal 300\n",
+		"blkio/docker/web/blkio.throttle.io_serviced":      "8:0 Read 1\n8:0 Write 2\nTotal 3\n",
+		"pids/docker/web/pids.current":                     "3\n",
+		"memory/docker/db/memory.usage_in_bytes":           "512\n",
+		"memory/docker/db/memory.limit_in_bytes":           "1024\n",
+	})
+
+	at := time.Unix(1500000000, 0)
+	c := &Collector{
+		Root:  root,
+		Spoof: func(path string) string { return "10.0.0.1:" + filepath.Base(path) },
+		now:   func() time.Time { return at },
+	}
+	v := collect(t, c)
+	checkValue(t, v, "10.0.0.1:web/heartbeat", 0)
+	checkValue(t, v, "10.0.0.1:web/cgroup_mem_usage", 1048576)
+	checkValue(t, v, "10.0.0.1:web/cgroup_pids", 3)
+	checkValue(t, v, "10.0.0.1:db/cgroup_mem_limit", 1024)
+	if _, ok := v["10.0.0.1:web/cgroup_mem_limit"]; ok {
+		t.Fatal("was not expecting a limit for an unlimited container")
+	}
+
+	writeFiles(t, root, map[string]string{
+		"cpu,cpuacct/docker/web/cpuacct.usage":             "1500000000\n",
+		"cpu,cpuacct/docker/web/cpuacct.stat":              "user 90\nsystem 60\n",
+		"blkio/docker/web/blkio.throttle.io_service_bytes": "8:0 Read 1100\n8:0 Write 200\n",
+		"blkio/docker/web/blkio.throttle.io_serviced":      "8:0 Read 11\n8:0 Write 2\n",
+	})
+	at = at.Add(time.Second)
+	v = collect(t, c)
+	checkValue(t, v, "10.0.0.1:web/cgroup_cpu_usage", 50)
+	checkValue(t, v, "10.0.0.1:web/cgroup_cpu_user", 30)
+	checkValue(t, v, "10.0.0.1:web/cgroup_cpu_system", 20)
+	checkValue(t, v, "10.0.0.1:web/cgroup_io_read_bytes", 1000)
+	checkValue(t, v, "10.0.0.1:web/cgroup_io_reads", 10)
+}
+
+func TestHeartbeatMetric(t *testing.T) {
+	t.Parallel()
+	ct := newContainer("a:a")
+	if !ct.heartbeat.Heartbeat || ct.heartbeat.Spoof != "a:a" {
+		t.Fatalf("unexpected heartbeat metric %+v", ct.heartbeat)
+	}
+	if ct.pids.ValueType != gmetric.ValueUint32 {
+		t.Fatalf("unexpected pids metric %+v", ct.pids)
+	}
+}
@@ -0,0 +1,209 @@
+package gmetric
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCardinality is returned for writes rejected by the cardinality limits
+// of the Client.
+var ErrCardinality = errors.New("gmetric: cardinality limit reached")
+
+// The name of the metric values are redirected to by OverflowAggregate.
+const OverflowName = "gmetric_overflow"
+
+type overflowAction int
+
+// The actions taken once a cardinality limit of the Client is reached.
+const (
+	// OverflowReject fails writes for new metrics with ErrCardinality.
+	OverflowReject = overflowAction(iota)
+
+	// OverflowAggregate redirects writes for new metrics to a single metric
+	// named OverflowName reported for the Client's own host. Whatever the
+	// overflowing metric, it is a uint32 counting the redirected value writes
+	// since the Client was created, which makes the overflow visible without
+	// creating new files in gmetad. Metadata writes are redirected without
+	// being counted.
+	OverflowAggregate
+)
+
+// Returns the Metric written instead of the metrics over the limits with
+// OverflowAggregate.
+func newOverflowMetric() *Metric {
+	return &Metric{
+		Name:        OverflowName,
+		Title:       "Cardinality Overflow",
+		Description: "The number of value writes redirected by the cardinality limits",
+		Units:       "writes",
+		Groups:      []string{"gmetric"},
+		ValueType:   ValueUint32,
+		Slope:       SlopePositive,
+	}
+}
+
+type cardinality struct {
+	names     map[string]time.Time
+	hosts     map[string]time.Time
+	overflows uint64
+	redirects uint64
+	overflow  *Metric
+	pruned    time.Time
+}
+
+// Returns the Metric and value to write instead of the given ones, which are
+// either the same or the overflow Metric and the number of redirected value
+// writes, or ErrCardinality. The val is nil for a metadata write. The
+// CardinalityHandler is called for every overflowing write.
+func (c *Client) admit(m *Metric, val interface{}) (*Metric, interface{}, error) {
+	if c.MaxMetrics <= 0 && c.MaxHosts <= 0 {
+		return m, val, nil
+	}
+
+	now := time.Now()
+	host, spoofed := m.reportedHost(c)
+	key := host + "/" + m.Name
+
+	c.cardMu.Lock()
+	ok := c.track(now, key, host, spoofed)
+	var overflow *Metric
+	var redirects uint64
+	if !ok {
+		c.card.overflows++
+		if c.Overflow == OverflowAggregate {
+			if c.card.overflow == nil {
+				c.card.overflow = newOverflowMetric()
+			}
+			if val != nil {
+				c.card.redirects++
+			}
+			overflow, redirects = c.card.overflow, c.card.redirects
+		}
+	}
+	c.cardMu.Unlock()
+	if ok {
+		return m, val, nil
+	}
+
+	if c.CardinalityHandler != nil {
+		c.CardinalityHandler(m)
+	}
+	if c.Overflow != OverflowAggregate {
+		return nil, nil, ErrCardinality
+	}
+	if val == nil {
+		return overflow, nil, nil
+	}
+	return overflow, uint32(redirects), nil
+}
+
+// Records the metric and its host as seen, and reports if it is within the
+// limits. Must be called with cardMu held.
+func (c *Client) track(now time.Time, key, host string, spoofed bool) bool {
+	card := &c.card
+	if card.names == nil {
+		card.names = make(map[string]time.Time)
+		card.hosts = make(map[string]time.Time)
+	}
+	if _, ok := card.names[key]; ok {
+		card.names[key] = now
+		if spoofed {
+			card.hosts[host] = now
+		}
+		return true
+	}
+
+	c.prune(now)
+	_, knownHost := card.hosts[host]
+	if c.MaxMetrics > 0 && len(card.names) >= c.MaxMetrics {
+		return false
+	}
+	if spoofed && !knownHost && c.MaxHosts > 0 && len(card.hosts) >= c.MaxHosts {
+		return false
+	}
+	card.names[key] = now
+	if spoofed {
+		card.hosts[host] = now
+	}
+	return true
+}
+
+// Forgets the metrics and hosts not written within the CardinalityWindow. The
+// maps are scanned at most once per tenth of the window.
+func (c *Client) prune(now time.Time) {
+	window := c.CardinalityWindow
+	if window <= 0 || now.Sub(c.card.pruned) < window/10 {
+		return
+	}
+	c.card.pruned = now
+	for key, at := range c.card.names {
+		if now.Sub(at) >= window {
+			delete(c.card.names, key)
+		}
+	}
+	for host, at := range c.card.hosts {
+		if now.Sub(at) >= window {
+			delete(c.card.hosts, host)
+		}
+	}
+}
+
+// CardinalityCollector returns a Collector providing the number of distinct
+// metrics and spoofed hosts tracked by the cardinality limits, and the
+// number of overflowing writes since the previous collection.
+func (c *Client) CardinalityCollector() Collector {
+	return &cardinalityCollector{
+		client: c,
+		metrics: &Metric{
+			Name:        "gmetric_distinct_metrics",
+			Title:       "Distinct Metrics",
+			Description: "The number of distinct metrics written in the cardinality window",
+			Units:       "metrics",
+			Groups:      []string{"gmetric"},
+			ValueType:   ValueUint32,
+			Slope:       SlopeBoth,
+		},
+		hosts: &Metric{
+			Name:        "gmetric_distinct_hosts",
+			Title:       "Distinct Spoofed Hosts",
+			Description: "The number of distinct spoofed hosts written in the cardinality window",
+			Units:       "hosts",
+			Groups:      []string{"gmetric"},
+			ValueType:   ValueUint32,
+			Slope:       SlopeBoth,
+		},
+		overflows: &Metric{
+			Name:        "gmetric_overflows",
+			Title:       "Cardinality Overflows",
+			Description: "The number of writes over the cardinality limits",
+			Units:       "writes",
+			Groups:      []string{"gmetric"},
+			ValueType:   ValueUint32,
+			Slope:       SlopeBoth,
+		},
+	}
+}
+
+type cardinalityCollector struct {
+	client                    *Client
+	metrics, hosts, overflows *Metric
+}
+
+func (cc *cardinalityCollector) Metrics() []*Metric {
+	return []*Metric{cc.metrics, cc.hosts, cc.overflows}
+}
+
+func (cc *cardinalityCollector) Collect() ([]Sample, error) {
+	c := cc.client
+	c.cardMu.Lock()
+	c.prune(time.Now())
+	metrics, hosts := len(c.card.names), len(c.card.hosts)
+	overflows := c.card.overflows
+	c.card.overflows = 0
+	c.cardMu.Unlock()
+	return []Sample{
+		{Metric: cc.metrics, Value: metrics},
+		{Metric: cc.hosts, Value: hosts},
+		{Metric: cc.overflows, Value: overflows},
+	}, nil
+}
@@ -0,0 +1,112 @@
+package gmetric_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+func TestCardinalityReject(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	c.MaxMetrics = 2
+	var overflowed []string
+	c.CardinalityHandler = func(m *gmetric.Metric) {
+		overflowed = append(overflowed, m.Name)
+	}
+
+	for i := 0; i < 3; i++ {
+		m := &gmetric.Metric{Name: fmt.Sprintf("user_%d", i), ValueType: gmetric.ValueUint32}
+		err := c.WriteValue(m, i)
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 && err != gmetric.ErrCardinality {
+			t.Fatalf("was expecting ErrCardinality but got %v", err)
+		}
+	}
+	// Known metrics can still be written.
+	if err := c.WriteValue(&gmetric.Metric{Name: "user_0", ValueType: gmetric.ValueUint32}, 7); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, r, "user_0", "0", "7")
+	checkValues(t, r, "user_2")
+	if len(overflowed) != 1 || overflowed[0] != "user_2" {
+		t.Fatalf("was expecting the handler to be called for user_2 but got %v", overflowed)
+	}
+
+	samples, err := c.CardinalityCollector().Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	for _, s := range samples {
+		values[s.Metric.Name] = s.Value
+	}
+	if values["gmetric_distinct_metrics"] != 2 || values["gmetric_overflows"] != uint64(1) {
+		t.Fatalf("unexpected cardinality samples %v", values)
+	}
+}
+
+func TestCardinalityHosts(t *testing.T) {
+	t.Parallel()
+	c, _ := newRecordingClient()
+	c.MaxHosts = 1
+	if err := c.WriteHeartbeat("10.0.0.1:a"); err != nil {
+		t.Fatal(err)
+	}
+	m := &gmetric.Metric{Name: "cpu", Spoof: "10.0.0.1:a", ValueType: gmetric.ValueUint32}
+	if err := c.WriteValue(m, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteHeartbeat("10.0.0.2:b"); err != gmetric.ErrCardinality {
+		t.Fatalf("was expecting ErrCardinality but got %v", err)
+	}
+	// Metrics for the Client's own host are not limited by MaxHosts.
+	if err := c.WriteValue(&gmetric.Metric{Name: "own", ValueType: gmetric.ValueUint32}, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCardinalityAggregate(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	c.MaxMetrics = 1
+	c.Overflow = gmetric.OverflowAggregate
+	writeValues(t, c, &gmetric.Metric{Name: "a", ValueType: gmetric.ValueUint32}, 1)
+	m := &gmetric.Metric{Name: "b", Spoof: "x:x", ValueType: gmetric.ValueFloat64, Units: "s"}
+	if err := c.WriteMeta(m); err != nil {
+		t.Fatal(err)
+	}
+	writeValues(t, c, m, 2.5)
+	writeValues(t, c, &gmetric.Metric{Name: "c", ValueType: gmetric.ValueString}, "up")
+	checkValues(t, r, "b")
+	checkValues(t, r, "c")
+	// The overflow counts the redirected value writes, not the meta.
+	checkValues(t, r, gmetric.OverflowName, "1", "2")
+	metas := r.Metas(gmetric.OverflowName)
+	if len(metas) != 1 || metas[0].Spoof || metas[0].Host != "localhost" {
+		t.Fatalf("was expecting an overflow meta for the own host but got %+v", metas)
+	}
+	if metas[0].ValueType != "uint32" || metas[0].Units != "writes" {
+		t.Fatalf("was expecting the fixed overflow metric but got %+v", metas[0])
+	}
+}
+
+func TestCardinalityWindow(t *testing.T) {
+	t.Parallel()
+	c, _ := newRecordingClient()
+	c.MaxMetrics = 1
+	c.CardinalityWindow = 50 * time.Millisecond
+	writeValues(t, c, &gmetric.Metric{Name: "a", ValueType: gmetric.ValueUint32}, 1)
+	b := &gmetric.Metric{Name: "b", ValueType: gmetric.ValueUint32}
+	if err := c.WriteValue(b, 1); err != gmetric.ErrCardinality {
+		t.Fatalf("was expecting ErrCardinality but got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := c.WriteValue(b, 1); err != nil {
+		t.Fatalf("was expecting the expired metric to be forgotten but got %v", err)
+	}
+}
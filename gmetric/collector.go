@@ -0,0 +1,229 @@
+package gmetric
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errNoClient = errors.New("gmetric: scheduler has no client")
+	errRunning  = errors.New("gmetric: scheduler is already running")
+)
+
+// Sample is a single value collected for a Metric.
+type Sample struct {
+	Metric *Metric
+	Value  interface{}
+}
+
+// A Collector provides a set of metrics, much like a gmond metric module.
+type Collector interface {
+	// Metrics describes the metrics the Collector provides. Collectors with a
+	// dynamic set of metrics may return only the ones known upfront, the
+	// Metric in each Sample is always authoritative.
+	Metrics() []*Metric
+
+	// Collect returns the current samples. Partial results may be returned
+	// along with an error.
+	Collect() ([]Sample, error)
+}
+
+// A Scheduler runs a set of Collectors on their own intervals and writes the
+// samples they return to a Client. Panics in a Collector are recovered and a
+// slow Collector only delays itself.
+type Scheduler struct {
+	// The Client the samples are written to.
+	Client *Client
+
+	// Timeout bounds how long a single Collect may take before it is reported
+	// as an error. A Collector which is still running when it is next due is
+	// skipped. Defaults to the interval of the Collector.
+	Timeout time.Duration
+
+	// Also known as send_metadata_interval, it defines how often the metadata
+	// is resent. If zero the metadata is only sent once per metric.
+	MetaInterval time.Duration
+
+	// Optional handler for errors from the Collectors and the Client.
+	ErrorHandler func(error)
+
+	mu       sync.Mutex
+	groups   []*collectionGroup
+	metaMu   sync.Mutex
+	metaSent map[string]time.Time
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type collectResult struct {
+	samples []Sample
+	err     error
+}
+
+type collectionGroup struct {
+	collector Collector
+	every     time.Duration
+	pending   chan collectResult
+}
+
+// Add a Collector to be run every given interval, also known as
+// collect_every. If zero the TickInterval of the Client is used. Collectors
+// must be added before calling Start.
+func (s *Scheduler) Add(c Collector, every time.Duration) {
+	s.groups = append(s.groups, &collectionGroup{collector: c, every: every})
+}
+
+// Start running the Collectors. The metadata for the metrics described by
+// the Collectors is sent immediately. Start fails if the Scheduler is already
+// running.
+func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return errRunning
+	}
+	if s.Client == nil {
+		return errNoClient
+	}
+	for _, g := range s.groups {
+		if g.every == 0 {
+			g.every = s.Client.TickInterval
+		}
+		if g.every <= 0 {
+			return fmt.Errorf("gmetric: no interval for collector %T", g.collector)
+		}
+	}
+
+	s.stop = make(chan struct{})
+	s.metaSent = make(map[string]time.Time)
+	for _, g := range s.groups {
+		for _, m := range g.collector.Metrics() {
+			s.writeMeta(m)
+		}
+	}
+	for _, g := range s.groups {
+		s.wg.Add(1)
+		go s.run(g)
+	}
+	return nil
+}
+
+// Stop running the Collectors. Collectors that are still running are
+// abandoned and their results discarded. Stop does nothing if the Scheduler
+// is not running.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	s.stop = nil
+}
+
+func (s *Scheduler) run(g *collectionGroup) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(g.every)
+	defer ticker.Stop()
+	for {
+		s.collect(g)
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) collect(g *collectionGroup) {
+	// A previous Collect that timed out is still running, skip this one. Its
+	// results are discarded once it finishes as they are stale.
+	if g.pending != nil {
+		select {
+		case <-g.pending:
+			g.pending = nil
+		default:
+			s.error(fmt.Errorf("gmetric: collector %T is still running", g.collector))
+			return
+		}
+	}
+
+	result := make(chan collectResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result <- collectResult{
+					err: fmt.Errorf("gmetric: collector %T panic: %v", g.collector, r),
+				}
+			}
+		}()
+		samples, err := g.collector.Collect()
+		result <- collectResult{samples: samples, err: err}
+	}()
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = g.every
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-result:
+		if r.err != nil {
+			s.error(r.err)
+		}
+		s.write(r.samples)
+	case <-timer.C:
+		g.pending = result
+		s.error(fmt.Errorf("gmetric: collector %T timed out after %s", g.collector, timeout))
+	case <-s.stop:
+	}
+}
+
+func (s *Scheduler) write(samples []Sample) {
+	for _, sample := range samples {
+		if sample.Metric == nil {
+			continue
+		}
+		if s.needsMeta(sample.Metric) {
+			s.writeMeta(sample.Metric)
+		}
+		if err := s.Client.WriteValue(sample.Metric, sample.Value); err != nil {
+			s.error(err)
+		}
+	}
+}
+
+func (s *Scheduler) needsMeta(m *Metric) bool {
+	// The daemon only treats a heartbeat as such when it has the metadata.
+	if m.Heartbeat {
+		return true
+	}
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	sent, ok := s.metaSent[m.key(s.Client)]
+	if !ok {
+		return true
+	}
+	return s.MetaInterval > 0 && time.Since(sent) >= s.MetaInterval
+}
+
+func (s *Scheduler) writeMeta(m *Metric) {
+	if err := s.Client.WriteMeta(m); err != nil {
+		s.error(err)
+		return
+	}
+	s.metaMu.Lock()
+	s.metaSent[m.key(s.Client)] = time.Now()
+	s.metaMu.Unlock()
+}
+
+func (s *Scheduler) error(err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+}
@@ -0,0 +1,263 @@
+package gmetric_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+type funcCollector struct {
+	metrics []*gmetric.Metric
+	collect func() ([]gmetric.Sample, error)
+}
+
+func (f *funcCollector) Metrics() []*gmetric.Metric {
+	return f.metrics
+}
+
+func (f *funcCollector) Collect() ([]gmetric.Sample, error) {
+	return f.collect()
+}
+
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (e *errorRecorder) Handle(err error) {
+	e.mu.Lock()
+	e.errs = append(e.errs, err)
+	e.mu.Unlock()
+}
+
+func (e *errorRecorder) Contains(str string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, err := range e.errs {
+		if strings.Contains(err.Error(), str) {
+			return true
+		}
+	}
+	return false
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func staticCollector(m *gmetric.Metric, val interface{}) *funcCollector {
+	return &funcCollector{
+		metrics: []*gmetric.Metric{m},
+		collect: func() ([]gmetric.Sample, error) {
+			return []gmetric.Sample{{Metric: m, Value: val}}, nil
+		},
+	}
+}
+
+func TestSchedulerWritesSamples(t *testing.T) {
+	t.Parallel()
+	client, r := newRecordingClient()
+	m := &gmetric.Metric{
+		Name:      "scheduled_metric",
+		ValueType: gmetric.ValueUint32,
+		Slope:     gmetric.SlopeBoth,
+	}
+	s := &gmetric.Scheduler{Client: client}
+	s.Add(staticCollector(m, 42), 5*time.Millisecond)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "values", func() bool { return len(r.Values(m.Name)) >= 3 })
+	s.Stop()
+
+	packets := r.Packets()
+	if !packets[0].Meta || packets[0].Name != m.Name {
+		t.Fatalf("was expecting meta first but got %+v", packets[0])
+	}
+	if metas := r.Metas(m.Name); len(metas) != 1 {
+		t.Fatalf("was expecting a single meta but got %d", len(metas))
+	}
+	for _, v := range r.Values(m.Name) {
+		if v != "42" {
+			t.Fatalf("was expecting 42 but got %s", v)
+		}
+	}
+}
+
+func TestSchedulerMetaInterval(t *testing.T) {
+	t.Parallel()
+	client, r := newRecordingClient()
+	m := &gmetric.Metric{Name: "meta_interval_metric", ValueType: gmetric.ValueUint32}
+	s := &gmetric.Scheduler{Client: client, MetaInterval: time.Nanosecond}
+	s.Add(staticCollector(m, 1), 5*time.Millisecond)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "metas", func() bool { return len(r.Metas(m.Name)) >= 3 })
+	s.Stop()
+}
+
+func TestSchedulerDynamicMetric(t *testing.T) {
+	t.Parallel()
+	client, r := newRecordingClient()
+	m := &gmetric.Metric{Name: "dynamic_metric", ValueType: gmetric.ValueString}
+	s := &gmetric.Scheduler{Client: client}
+	s.Add(&funcCollector{
+		collect: func() ([]gmetric.Sample, error) {
+			return []gmetric.Sample{{Metric: m, Value: "v"}}, nil
+		},
+	}, 5*time.Millisecond)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "values", func() bool { return len(r.Values(m.Name)) >= 2 })
+	s.Stop()
+
+	packets := r.Packets()
+	if !packets[0].Meta || packets[0].Name != m.Name {
+		t.Fatalf("was expecting meta first but got %+v", packets[0])
+	}
+	if metas := r.Metas(m.Name); len(metas) != 1 {
+		t.Fatalf("was expecting a single meta but got %d", len(metas))
+	}
+}
+
+func TestSchedulerIsolatesPanicsAndSlowCollectors(t *testing.T) {
+	t.Parallel()
+	client, r := newRecordingClient()
+	errs := &errorRecorder{}
+	s := &gmetric.Scheduler{
+		Client:       client,
+		Timeout:      10 * time.Millisecond,
+		ErrorHandler: errs.Handle,
+	}
+
+	s.Add(&funcCollector{
+		collect: func() ([]gmetric.Sample, error) {
+			panic("collector blew up")
+		},
+	}, 5*time.Millisecond)
+
+	block := make(chan struct{})
+	defer close(block)
+	slow := &gmetric.Metric{Name: "slow_metric", ValueType: gmetric.ValueUint32}
+	s.Add(&funcCollector{
+		collect: func() ([]gmetric.Sample, error) {
+			<-block
+			return []gmetric.Sample{{Metric: slow, Value: 1}}, nil
+		},
+	}, 5*time.Millisecond)
+
+	healthy := &gmetric.Metric{Name: "healthy_metric", ValueType: gmetric.ValueUint32}
+	s.Add(staticCollector(healthy, 1), 5*time.Millisecond)
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "panic error", func() bool { return errs.Contains("collector blew up") })
+	waitFor(t, "timeout error", func() bool { return errs.Contains("timed out") })
+	waitFor(t, "still running error", func() bool { return errs.Contains("still running") })
+	waitFor(t, "healthy values", func() bool { return len(r.Values(healthy.Name)) >= 3 })
+	s.Stop()
+
+	if values := r.Values(slow.Name); len(values) != 0 {
+		t.Fatalf("was not expecting values from the slow collector but got %v", values)
+	}
+}
+
+func TestSchedulerPartialResults(t *testing.T) {
+	t.Parallel()
+	client, r := newRecordingClient()
+	errs := &errorRecorder{}
+	m := &gmetric.Metric{Name: "partial_metric", ValueType: gmetric.ValueUint32}
+	s := &gmetric.Scheduler{Client: client, ErrorHandler: errs.Handle}
+	s.Add(&funcCollector{
+		collect: func() ([]gmetric.Sample, error) {
+			return []gmetric.Sample{{Metric: m, Value: 1}}, errors.New("partial failure")
+		},
+	}, 5*time.Millisecond)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "values", func() bool { return len(r.Values(m.Name)) >= 1 })
+	waitFor(t, "error", func() bool { return errs.Contains("partial failure") })
+	s.Stop()
+}
+
+func TestSchedulerNoInterval(t *testing.T) {
+	t.Parallel()
+	client, _ := newRecordingClient()
+	s := &gmetric.Scheduler{Client: client}
+	s.Add(&funcCollector{}, 0)
+	errContains(t, s.Start(), "gmetric: no interval for collector")
+}
+
+func TestSchedulerNoClient(t *testing.T) {
+	t.Parallel()
+	s := &gmetric.Scheduler{}
+	errContains(t, s.Start(), "gmetric: scheduler has no client")
+}
+
+func TestSchedulerStopWithoutStart(t *testing.T) {
+	t.Parallel()
+	s := &gmetric.Scheduler{}
+	s.Stop()
+	errContains(t, s.Start(), "gmetric: scheduler has no client")
+	s.Stop()
+}
+
+func TestSchedulerStartTwice(t *testing.T) {
+	t.Parallel()
+	client, _ := newRecordingClient()
+	s := &gmetric.Scheduler{Client: client}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	errContains(t, s.Start(), "gmetric: scheduler is already running")
+	s.Stop()
+	if err := s.Start(); err != nil {
+		t.Fatalf("was expecting a restart after Stop to work but got %v", err)
+	}
+	s.Stop()
+}
+
+func TestSchedulerConcurrentStop(t *testing.T) {
+	t.Parallel()
+	client, _ := newRecordingClient()
+	m := &gmetric.Metric{Name: "concurrent_stop_metric", ValueType: gmetric.ValueUint32}
+	s := &gmetric.Scheduler{Client: client}
+	s.Add(staticCollector(m, 1), time.Millisecond)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSchedulerStopTwice(t *testing.T) {
+	t.Parallel()
+	client, _ := newRecordingClient()
+	s := &gmetric.Scheduler{Client: client}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s.Stop()
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/facebookgo/flag.addrs"
@@ -101,7 +102,42 @@ type Client struct {
 	// eligible for garbage collection.
 	Lifetime time.Duration
 
+	// Defines how metric names that are not safe as file names are handled
+	// when writing. Defaults to NamesUnchecked.
+	NamePolicy namePolicy
+
+	// Optional limit on the length of the metric names. Longer names are
+	// rejected, unless the NamePolicy is NamesReplace or NamesEscape in which
+	// case they are truncated and end with a hash of the full name.
+	MaxNameLength int
+
+	// Optional limit on the number of distinct metrics, by host and name,
+	// written within the CardinalityWindow.
+	MaxMetrics int
+
+	// Optional limit on the number of distinct spoofed hosts written within
+	// the CardinalityWindow.
+	MaxHosts int
+
+	// The window after which a metric or host that has not been written is
+	// no longer counted towards the limits. If zero they are counted forever.
+	CardinalityWindow time.Duration
+
+	// Defines what happens to writes for new metrics once a limit is reached.
+	// Defaults to OverflowReject.
+	Overflow overflowAction
+
+	// Optional handler called with the Metric of every write over the limits.
+	CardinalityHandler func(*Metric)
+
 	conn []net.Conn
+
+	cardMu sync.Mutex
+	card   cardinality
+
+	sentMu    sync.Mutex
+	sent      map[string]sentValue
+	sentPrune int
 }
 
 // Metric configuration.
@@ -122,6 +158,10 @@ type Metric struct {
 	// The units are shown in the graph to provide context to the numbers.
 	Units string
 
+	// Optional dimensions of the metric, sent as "LABEL_<name>" extras. See
+	// LabeledMetric.
+	Labels Labels
+
 	// The actual hostname for the machine.
 	Host string
 
@@ -129,6 +169,10 @@ type Metric struct {
 	// allows for overriding the hostname to make it useful.
 	Spoof string
 
+	// Marks the metric as the heartbeat for the spoofed host, which keeps the
+	// host alive in the daemon. See HeartbeatMetric.
+	Heartbeat bool
+
 	// Defines the value type. You must specify one of the predefined constants.
 	ValueType valueType
 
@@ -144,6 +188,35 @@ type Metric struct {
 	// the last received metric is older than the defined value it will become
 	// eligible for garbage collection.
 	Lifetime time.Duration
+
+	// Also known as value_threshold, if set a numeric value is only sent if it
+	// differs from the last sent value by more than the threshold or once the
+	// TimeThreshold expires. Unchanged values are suppressed only when written
+	// more often than the TimeThreshold, so a metric written once per tick,
+	// like a Collector added to a Scheduler without an interval, is always
+	// resent to keep it within TMax.
+	ValueThreshold float64
+
+	// Also known as time_threshold, if set an unchanged value is only resent
+	// once the threshold expires. The threshold defaults to and is capped at
+	// the TickInterval less a tenth of it, so the daemon always receives an
+	// update within TMax even when the value is written once per tick with
+	// some jitter.
+	TimeThreshold time.Duration
+}
+
+// HasGroups returns true if the Metric has exactly the given groups, in the
+// same order.
+func (m *Metric) HasGroups(groups []string) bool {
+	if len(m.Groups) != len(groups) {
+		return false
+	}
+	for i := range groups {
+		if m.Groups[i] != groups[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // Writes a metadata packet for the Metric.
@@ -195,10 +268,16 @@ func (m *Metric) writeMeta(c *Client, w io.Writer) (err error) {
 	if spoof != "" {
 		extras = append(extras, [2]string{"SPOOF_HOST", spoof})
 	}
+	if m.Heartbeat {
+		extras = append(extras, [2]string{"SPOOF_HEARTBEAT", "yes"})
+	}
 
 	for _, group := range m.Groups {
 		extras = append(extras, [2]string{"GROUP", group})
 	}
+	for _, name := range m.Labels.names() {
+		extras = append(extras, [2]string{"LABEL_" + name, m.Labels[name]})
+	}
 	writeExtras(pw, extras)
 	return
 }
@@ -225,28 +304,38 @@ func (m *Metric) writeValue(c *Client, w io.Writer, val interface{}) (err error)
 }
 
 func (m *Metric) writeHead(c *Client, w io.Writer) {
-	host := m.Host
-	if host == "" {
-		host = c.Host
+	host, hasSpoof := m.reportedHost(c)
+	writeString(w, host)
+	writeString(w, m.Name)
+	if hasSpoof {
+		writeUint32(w, 1)
+	} else {
+		writeUint32(w, 0)
 	}
+}
 
+// Returns the host the Metric is reported for, and if it is spoofed.
+func (m *Metric) reportedHost(c *Client) (string, bool) {
 	spoof := m.Spoof
 	if spoof == "" {
 		spoof = c.Spoof
 	}
-
-	hasSpoof := spoof != ""
-	if hasSpoof {
-		writeString(w, spoof)
-	} else {
-		writeString(w, host)
+	if spoof != "" {
+		return spoof, true
 	}
-	writeString(w, m.Name)
-	if hasSpoof {
-		writeUint32(w, 1)
-	} else {
-		writeUint32(w, 0)
+
+	host := m.Host
+	if host == "" {
+		host = c.Host
 	}
+	return host, false
+}
+
+// Returns a key that uniquely identifies the Metric on the host it is
+// reported for.
+func (m *Metric) key(c *Client) string {
+	host, _ := m.reportedHost(c)
+	return host + "/" + m.Name
 }
 
 func (c *Client) writeCheck(m *Metric) error {
@@ -267,6 +356,13 @@ func (c *Client) WriteMeta(m *Metric) error {
 	if err := c.writeCheck(m); err != nil {
 		return err
 	}
+	m, err := c.checkName(m)
+	if err != nil {
+		return err
+	}
+	if m, _, err = c.admit(m, nil); err != nil {
+		return err
+	}
 	var buf bytes.Buffer
 	if err := m.writeMeta(c, &buf); err != nil {
 		return err
@@ -277,21 +373,57 @@ func (c *Client) WriteMeta(m *Metric) error {
 	return nil
 }
 
-// WriteValue writes a value for the Metric.
+// WriteValue writes a value for the Metric. If the Metric has a
+// ValueThreshold or TimeThreshold the value may be suppressed, in which case
+// nothing is written and nil is returned.
 func (c *Client) WriteValue(m *Metric, val interface{}) error {
 	if err := c.writeCheck(m); err != nil {
 		return err
 	}
+	m, err := c.checkName(m)
+	if err != nil {
+		return err
+	}
+	if m, val, err = c.admit(m, val); err != nil {
+		return err
+	}
+	if !c.shouldSend(m, val) {
+		return nil
+	}
 	var buf bytes.Buffer
 	if err := m.writeValue(c, &buf, val); err != nil {
+		c.forgetSent(m)
 		return err
 	}
 	if _, err := c.Write(buf.Bytes()); err != nil {
+		c.forgetSent(m)
 		return err
 	}
 	return nil
 }
 
+// HeartbeatMetric returns the Metric used to send a heartbeat for the given
+// spoofed host, the same as `gmetric --heartbeat`. Its value is always 0.
+func HeartbeatMetric(spoof string) *Metric {
+	return &Metric{
+		Name:      "heartbeat",
+		Spoof:     spoof,
+		Heartbeat: true,
+		ValueType: ValueUint32,
+		Slope:     SlopeZero,
+	}
+}
+
+// WriteHeartbeat writes the metadata and value for the heartbeat of the given
+// spoofed host.
+func (c *Client) WriteHeartbeat(spoof string) error {
+	m := HeartbeatMetric(spoof)
+	if err := c.WriteMeta(m); err != nil {
+		return err
+	}
+	return c.WriteValue(m, 0)
+}
+
 // Open the connections. If an error is returned it will be a MultiError.
 func (c *Client) Open() error {
 	if len(c.Addr) == 0 {
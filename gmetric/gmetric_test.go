@@ -2,6 +2,7 @@ package gmetric_test
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -385,3 +386,49 @@ func TestNoValueType(t *testing.T) {
 	errContains(t, h.Client.WriteMeta(m), "gmetric: metric has no ValueType")
 	errContains(t, h.Client.WriteValue(m, "val"), "gmetric: metric has no ValueType")
 }
+
+func TestWriteHeartbeat(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	if err := c.WriteHeartbeat("10.0.0.1:device"); err != nil {
+		t.Fatal(err)
+	}
+	metas := r.Metas("heartbeat")
+	if len(metas) != 1 {
+		t.Fatalf("was expecting one meta but got %d", len(metas))
+	}
+	meta := metas[0]
+	if meta.Host != "10.0.0.1:device" || !meta.Spoof || meta.ValueType != "uint32" {
+		t.Fatalf("unexpected heartbeat meta %+v", meta)
+	}
+	expected := [][2]string{
+		{"SPOOF_HOST", "10.0.0.1:device"},
+		{"SPOOF_HEARTBEAT", "yes"},
+	}
+	if !reflect.DeepEqual(meta.Extras, expected) {
+		t.Fatalf("was expecting extras %v but got %v", expected, meta.Extras)
+	}
+	checkValues(t, r, "heartbeat", "0")
+}
+
+func TestHasGroups(t *testing.T) {
+	t.Parallel()
+	m := &gmetric.Metric{Groups: []string{"a", "b"}}
+	cases := []struct {
+		Groups []string
+		Has    bool
+	}{
+		{[]string{"a", "b"}, true},
+		{[]string{"b", "a"}, false},
+		{[]string{"a"}, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if actual := m.HasGroups(c.Groups); actual != c.Has {
+			t.Fatalf("HasGroups(%q) = %v, expected %v", c.Groups, actual, c.Has)
+		}
+	}
+	if !(&gmetric.Metric{}).HasGroups(nil) {
+		t.Fatal("expected no groups to match nil")
+	}
+}
@@ -0,0 +1,162 @@
+package gmetric
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The name suffix of the series folded together once the MaxSeries of a
+// LabeledMetric is reached. EscapeLabel escapes every "%", so no label set
+// gives the same name.
+const OtherSeries = "%other"
+
+// The labels of the folded series.
+var otherLabels = Labels{"folded": "true"}
+
+// The default limit on the number of series of a LabeledMetric.
+const defaultMaxSeries = 100
+
+// Labels are the dimensions of a series, such as endpoint=login or dc=east.
+type Labels map[string]string
+
+// Returns the label names in sorted order.
+func (l Labels) names() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LabeledMetric is a family of Metrics distinguished by their Labels. The
+// name of each series is the Name of the template followed by the sorted
+// label names and values separated by a dot, for example the labels
+// endpoint=login and dc=east on "requests" give the series
+// "requests.dc.east.endpoint.login". Bytes in label names and values other
+// than letters, digits, underscores and dashes are escaped as "%XX", like
+// NamesEscape does, so distinct label sets always give distinct series. The
+// escaped names are only kept distinct by a Client using NamesUnchecked or
+// NamesEscape. The labels are also sent unescaped as "LABEL_<name>" extras in
+// the metadata.
+type LabeledMetric struct {
+	// The template for the series. All fields but the name, groups and labels
+	// are used as is.
+	Metric
+
+	// The labels whose "<name>_<value>" is added to the Groups of the series.
+	GroupLabels []string
+
+	// The maximum number of distinct series. Once reached, new label sets are
+	// folded into a single series named "<name>.%other", which has the groups
+	// of the template and the label folded=true. Defaults to 100.
+	MaxSeries int
+
+	mu     sync.Mutex
+	series map[string]*Metric
+	other  *Metric
+}
+
+// With returns the Metric for the series with the given labels. The same
+// Metric is returned for the same labels.
+func (l *LabeledMetric) With(labels Labels) *Metric {
+	name := l.seriesName(labels)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if m, ok := l.series[name]; ok {
+		return m
+	}
+
+	max := l.MaxSeries
+	if max <= 0 {
+		max = defaultMaxSeries
+	}
+	if len(l.series) >= max {
+		if l.other == nil {
+			other := l.Metric
+			other.Name = l.Name + "." + OtherSeries
+			other.Labels = make(Labels, len(otherLabels))
+			for name, value := range otherLabels {
+				other.Labels[name] = value
+			}
+			other.Groups = append([]string(nil), l.Groups...)
+			l.other = &other
+		}
+		return l.other
+	}
+
+	if l.series == nil {
+		l.series = make(map[string]*Metric)
+	}
+	m := l.newSeries(name, labels)
+	l.series[name] = m
+	return m
+}
+
+// Series returns the Metrics for the series created so far, sorted by name.
+// The folded series, if any, is last.
+func (l *LabeledMetric) Series() []*Metric {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.series))
+	for name := range l.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	series := make([]*Metric, 0, len(names)+1)
+	for _, name := range names {
+		series = append(series, l.series[name])
+	}
+	if l.other != nil {
+		series = append(series, l.other)
+	}
+	return series
+}
+
+func (l *LabeledMetric) seriesName(labels Labels) string {
+	var buf strings.Builder
+	buf.WriteString(l.Name)
+	for _, name := range labels.names() {
+		buf.WriteByte('.')
+		buf.WriteString(EscapeLabel(name))
+		buf.WriteByte('.')
+		buf.WriteString(EscapeLabel(labels[name]))
+	}
+	return buf.String()
+}
+
+func (l *LabeledMetric) newSeries(name string, labels Labels) *Metric {
+	m := l.Metric
+	m.Name = name
+	m.Labels = make(Labels, len(labels))
+	for label, value := range labels {
+		m.Labels[label] = value
+	}
+	m.Groups = append([]string(nil), l.Groups...)
+	for _, label := range l.GroupLabels {
+		if value, ok := labels[label]; ok {
+			m.Groups = append(m.Groups, EscapeLabel(label)+"_"+EscapeLabel(value))
+		}
+	}
+	return &m
+}
+
+// EscapeLabel escapes the bytes other than letters, digits, underscores and
+// dashes as "%XX", as LabeledMetric does for label names and values. The dot
+// is escaped as it separates the labels in the name, and the percent sign as
+// it starts an escape.
+func EscapeLabel(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b != '.' && safeByte(b) {
+			buf.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,107 @@
+package gmetric_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+func TestLabeledMetricNames(t *testing.T) {
+	t.Parallel()
+	l := &gmetric.LabeledMetric{
+		Metric: gmetric.Metric{
+			Name:      "requests",
+			Groups:    []string{"http"},
+			ValueType: gmetric.ValueFloat64,
+			Slope:     gmetric.SlopeBoth,
+		},
+		GroupLabels: []string{"dc"},
+	}
+	m := l.With(gmetric.Labels{"endpoint": "log in/out", "dc": "east"})
+	if m.Name != "requests.dc.east.endpoint.log%20in%2Fout" {
+		t.Fatalf("unexpected name %s", m.Name)
+	}
+	if !reflect.DeepEqual(m.Groups, []string{"http", "dc_east"}) {
+		t.Fatalf("unexpected groups %v", m.Groups)
+	}
+	if m.ValueType != gmetric.ValueFloat64 || m.Slope != gmetric.SlopeBoth {
+		t.Fatalf("was expecting the template types but got %s and %s", m.ValueType, m.Slope)
+	}
+	if again := l.With(gmetric.Labels{"dc": "east", "endpoint": "log in/out"}); again != m {
+		t.Fatal("was expecting the same metric for the same labels")
+	}
+	if l.Groups[0] != "http" || len(l.Groups) != 1 {
+		t.Fatalf("was not expecting the template groups to change but got %v", l.Groups)
+	}
+	if n := l.With(nil).Name; n != "requests" {
+		t.Fatalf("was expecting the plain name without labels but got %s", n)
+	}
+}
+
+func TestLabeledMetricEscapesLabels(t *testing.T) {
+	t.Parallel()
+	l := &gmetric.LabeledMetric{
+		Metric: gmetric.Metric{Name: "hits", ValueType: gmetric.ValueUint32},
+	}
+	seen := make(map[string]string)
+	for _, value := range []string{"a.b", "a_b", "a%2Eb", "a b", ""} {
+		name := l.With(gmetric.Labels{"path": value}).Name
+		if other, ok := seen[name]; ok {
+			t.Fatalf("was expecting distinct series for %q and %q but both got %s", other, value, name)
+		}
+		seen[name] = value
+	}
+	if n := l.With(gmetric.Labels{"path": "a.b"}).Name; n != "hits.path.a%2Eb" {
+		t.Fatalf("unexpected name %s", n)
+	}
+}
+
+func TestLabeledMetricOverflow(t *testing.T) {
+	t.Parallel()
+	l := &gmetric.LabeledMetric{
+		Metric:    gmetric.Metric{Name: "hits", ValueType: gmetric.ValueUint32},
+		MaxSeries: 2,
+	}
+	l.With(gmetric.Labels{"user": "other"})
+	l.With(gmetric.Labels{"user": "b"})
+	c := l.With(gmetric.Labels{"user": "c"})
+	d := l.With(gmetric.Labels{"user": "other", "dc": "east"})
+	if c != d || c.Name != "hits.%other" {
+		t.Fatalf("was expecting the overflow to fold into hits.%%other but got %s and %s", c.Name, d.Name)
+	}
+	if !reflect.DeepEqual(c.Labels, gmetric.Labels{"folded": "true"}) {
+		t.Fatalf("was expecting the fixed folded labels but got %v", c.Labels)
+	}
+	if b := l.With(gmetric.Labels{"user": "b"}); b.Name != "hits.user.b" {
+		t.Fatalf("was expecting the existing series but got %s", b.Name)
+	}
+	var names []string
+	for _, m := range l.Series() {
+		names = append(names, m.Name)
+	}
+	expected := []string{"hits.user.b", "hits.user.other", "hits.%other"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("was expecting %v but got %v", expected, names)
+	}
+}
+
+func TestLabelsInMeta(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	l := &gmetric.LabeledMetric{
+		Metric: gmetric.Metric{Name: "queue", ValueType: gmetric.ValueUint32},
+	}
+	m := l.With(gmetric.Labels{"name": "log jobs", "dc": "west"})
+	if err := c.WriteMeta(m); err != nil {
+		t.Fatal(err)
+	}
+	metas := r.Metas("queue.dc.west.name.log%20jobs")
+	if len(metas) != 1 {
+		t.Fatalf("was expecting 1 meta but got %d", len(metas))
+	}
+	expected := [][2]string{{"LABEL_dc", "west"}, {"LABEL_name", "log jobs"}}
+	if !reflect.DeepEqual(metas[0].Extras, expected) {
+		t.Fatalf("was expecting extras %v but got %v", expected, metas[0].Extras)
+	}
+}
@@ -0,0 +1,166 @@
+package gmetric
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+var (
+	errUnsafeName  = errors.New("gmetric: metric name has unsafe characters")
+	errLongName    = errors.New("gmetric: metric name is too long")
+	errNoNameParts = errors.New("gmetric: name template produced an empty name")
+)
+
+type namePolicy int
+
+// The policies for metric names with characters that are not safe in a file
+// name. Letters, digits, underscores, dashes and dots are safe, but a name
+// may not be "." or "..".
+const (
+	// NamesUnchecked sends the names as is.
+	NamesUnchecked = namePolicy(iota)
+
+	// NamesReject fails writes for unsafe names.
+	NamesReject
+
+	// NamesReplace replaces every unsafe byte with an underscore.
+	NamesReplace
+
+	// NamesEscape replaces every unsafe byte with its "%XX" hex escape, which
+	// unlike NamesReplace keeps distinct names distinct.
+	NamesEscape
+)
+
+// The length of the hash suffix added to names truncated to the
+// MaxNameLength, an underscore followed by 8 hex digits.
+const nameHashLength = 9
+
+// Returns the Metric with a name according to the NamePolicy and
+// MaxNameLength of the Client. The Metric is copied if the name changes.
+func (c *Client) checkName(m *Metric) (*Metric, error) {
+	if c.NamePolicy == NamesUnchecked && c.MaxNameLength <= 0 {
+		return m, nil
+	}
+	name, err := c.NamePolicy.apply(m.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %q", err, m.Name)
+	}
+	if max := c.MaxNameLength; max > 0 && len(name) > max {
+		if c.NamePolicy == NamesUnchecked || c.NamePolicy == NamesReject || max <= nameHashLength {
+			return nil, fmt.Errorf("%s: %q", errLongName, m.Name)
+		}
+		name = truncateName(name, max)
+	}
+	if name == m.Name {
+		return m, nil
+	}
+	renamed := *m
+	renamed.Name = name
+	return &renamed, nil
+}
+
+func (p namePolicy) apply(name string) (string, error) {
+	if p == NamesUnchecked || safeName(name) {
+		return name, nil
+	}
+	switch p {
+	case NamesReplace:
+		return replaceUnsafe(name, func(b byte) string { return "_" }), nil
+	case NamesEscape:
+		return replaceUnsafe(name, func(b byte) string { return fmt.Sprintf("%%%02X", b) }), nil
+	}
+	return "", errUnsafeName
+}
+
+// Reports if the name is safe to use as a file name.
+func safeName(name string) bool {
+	if name == "." || name == ".." {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !safeByte(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func safeByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' ||
+		b == '_' || b == '-' || b == '.'
+}
+
+// Replaces the unsafe bytes of the name, and the dots of a name that is only
+// dots.
+func replaceUnsafe(name string, replace func(byte) string) string {
+	dotsOnly := name == "." || name == ".."
+	var buf strings.Builder
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if safeByte(b) && !dotsOnly {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteString(replace(b))
+		}
+	}
+	return buf.String()
+}
+
+// Truncates the name to the given length, replacing the end with a hash of
+// the whole name so truncated names stay distinct.
+func truncateName(name string, max int) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("%s_%08x", name[:max-nameHashLength], h.Sum32())
+}
+
+// NameTemplate builds consistent metric names from their parts. The
+// non-empty parts are joined by the Separator, and the unsafe characters in
+// each part are replaced with an underscore.
+type NameTemplate struct {
+	// The organization wide prefix, such as "fb".
+	Prefix string
+
+	// The service the metrics belong to, such as "api".
+	Service string
+
+	// The instance of the service, such as "east-1".
+	Instance string
+
+	// The suffix added after the metric name, such as "rate".
+	Suffix string
+
+	// The separator between the parts. Defaults to ".".
+	Separator string
+}
+
+// Name returns the full name for the given metric name.
+func (t *NameTemplate) Name(name string) string {
+	sep := t.Separator
+	if sep == "" {
+		sep = "."
+	}
+	var parts []string
+	for _, part := range []string{t.Prefix, t.Service, t.Instance, name, t.Suffix} {
+		if part == "" {
+			continue
+		}
+		part, _ = NamesReplace.apply(part)
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, sep)
+}
+
+// Metric returns a copy of the Metric with the full name, or an error if the
+// name would be empty.
+func (t *NameTemplate) Metric(m *Metric) (*Metric, error) {
+	name := t.Name(m.Name)
+	if name == "" {
+		return nil, errNoNameParts
+	}
+	named := *m
+	named.Name = name
+	return &named, nil
+}
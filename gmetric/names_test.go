@@ -0,0 +1,109 @@
+package gmetric_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+func writeName(t *testing.T, c *gmetric.Client, name string) error {
+	return c.WriteValue(&gmetric.Metric{Name: name, ValueType: gmetric.ValueUint32}, 1)
+}
+
+func checkNames(t *testing.T, r *recorder, expected ...string) {
+	var names []string
+	for _, p := range r.Packets() {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, "|") != strings.Join(expected, "|") {
+		t.Fatalf("was expecting names %q but got %q", expected, names)
+	}
+}
+
+func TestNamesUnchecked(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	if err := writeName(t, c, "a b/c"); err != nil {
+		t.Fatal(err)
+	}
+	checkNames(t, r, "a b/c")
+}
+
+func TestNamesReject(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	c.NamePolicy = gmetric.NamesReject
+	for _, name := range []string{"a b", "a/b", "ünï", "..", "."} {
+		err := writeName(t, c, name)
+		if err == nil || !strings.Contains(err.Error(), "unsafe") {
+			t.Fatalf("was expecting an unsafe name error for %q but got %v", name, err)
+		}
+	}
+	if err := writeName(t, c, "ok_name-1.2"); err != nil {
+		t.Fatal(err)
+	}
+	checkNames(t, r, "ok_name-1.2")
+}
+
+func TestNamesReplaceAndEscape(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	c.NamePolicy = gmetric.NamesReplace
+	if err := writeName(t, c, "a b/c"); err != nil {
+		t.Fatal(err)
+	}
+	c.NamePolicy = gmetric.NamesEscape
+	if err := writeName(t, c, "a b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeName(t, c, ".."); err != nil {
+		t.Fatal(err)
+	}
+	checkNames(t, r, "a_b_c", "a%20b%2Fc", "%2E%2E")
+}
+
+func TestMaxNameLength(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	c.MaxNameLength = 16
+	if err := writeName(t, c, strings.Repeat("x", 17)); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Fatalf("was expecting a too long error but got %v", err)
+	}
+
+	c.NamePolicy = gmetric.NamesReplace
+	long := strings.Repeat("x", 20)
+	if err := writeName(t, c, long+"a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeName(t, c, long+"b"); err != nil {
+		t.Fatal(err)
+	}
+	packets := r.Packets()
+	if len(packets) != 2 {
+		t.Fatalf("was expecting 2 packets but got %d", len(packets))
+	}
+	a, b := packets[0].Name, packets[1].Name
+	if len(a) != 16 || len(b) != 16 || a == b || !strings.HasPrefix(a, "xxxxxxx_") {
+		t.Fatalf("was expecting distinct truncated names but got %q and %q", a, b)
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	t.Parallel()
+	tmpl := &gmetric.NameTemplate{Prefix: "fb", Service: "api", Instance: "east 1", Suffix: "rate"}
+	if name := tmpl.Name("requests"); name != "fb.api.east_1.requests.rate" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	tmpl = &gmetric.NameTemplate{Service: "api", Separator: "_"}
+	m, err := tmpl.Metric(&gmetric.Metric{Name: "login/ok", Units: "req"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "api_login_ok" || m.Units != "req" {
+		t.Fatalf("unexpected metric %+v", m)
+	}
+	if _, err := (&gmetric.NameTemplate{}).Metric(&gmetric.Metric{}); err == nil {
+		t.Fatal("was expecting an error for an empty name")
+	}
+}
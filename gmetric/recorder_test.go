@@ -0,0 +1,137 @@
+package gmetric_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// packet is a decoded gmetric meta or value packet.
+type packet struct {
+	Meta      bool
+	Host      string
+	Name      string
+	Spoof     bool
+	ValueType string
+	Units     string
+	Slope     uint32
+	Tmax      uint32
+	Dmax      uint32
+	Extras    [][2]string
+	Value     string
+}
+
+// recorder is a Writer that decodes and records the packets written to it.
+type recorder struct {
+	mu      sync.Mutex
+	packets []packet
+}
+
+func newRecordingClient() (*gmetric.Client, *recorder) {
+	r := &recorder{}
+	return &gmetric.Client{Writer: r, Host: "localhost"}, r
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	p, err := decodePacket(b)
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	r.packets = append(r.packets, p)
+	r.mu.Unlock()
+	return len(b), nil
+}
+
+// Packets returns a copy of the packets recorded so far.
+func (r *recorder) Packets() []packet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]packet(nil), r.packets...)
+}
+
+// Values returns the values recorded for the named metric.
+func (r *recorder) Values(name string) []string {
+	var values []string
+	for _, p := range r.Packets() {
+		if !p.Meta && p.Name == name {
+			values = append(values, p.Value)
+		}
+	}
+	return values
+}
+
+// Metas returns the meta packets recorded for the named metric.
+func (r *recorder) Metas(name string) []packet {
+	var metas []packet
+	for _, p := range r.Packets() {
+		if p.Meta && p.Name == name {
+			metas = append(metas, p)
+		}
+	}
+	return metas
+}
+
+type packetDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *packetDecoder) uint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 4 {
+		d.err = fmt.Errorf("short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *packetDecoder) string() string {
+	l := int(d.uint32())
+	padded := (l + 3) / 4 * 4
+	if d.err != nil {
+		return ""
+	}
+	if len(d.b) < padded {
+		d.err = fmt.Errorf("short packet")
+		return ""
+	}
+	s := string(d.b[:l])
+	d.b = d.b[padded:]
+	return s
+}
+
+func decodePacket(b []byte) (packet, error) {
+	d := &packetDecoder{b: b}
+	var p packet
+	kind := d.uint32()
+	p.Host = d.string()
+	p.Name = d.string()
+	p.Spoof = d.uint32() == 1
+	switch kind {
+	case 128:
+		p.Meta = true
+		p.ValueType = d.string()
+		d.string() // name again
+		p.Units = d.string()
+		p.Slope = d.uint32()
+		p.Tmax = d.uint32()
+		p.Dmax = d.uint32()
+		n := d.uint32()
+		for i := uint32(0); i < n && d.err == nil; i++ {
+			p.Extras = append(p.Extras, [2]string{d.string(), d.string()})
+		}
+	case 133:
+		d.string() // format
+		p.Value = d.string()
+	default:
+		return p, fmt.Errorf("unknown packet type %d", kind)
+	}
+	return p, d.err
+}
@@ -0,0 +1,79 @@
+package gmetric
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The default number of values kept by a Reservoir.
+const defaultReservoirSize = 1024
+
+// Reservoir keeps a uniform random sample of the observed values in bounded
+// memory, to derive percentiles from. It is not safe for concurrent use.
+type Reservoir struct {
+	// The number of values kept. Defaults to 1024.
+	Size int
+
+	seen   uint64
+	values []float64
+	sorted bool
+}
+
+// Observe adds a value to the sample.
+func (r *Reservoir) Observe(v float64) {
+	size := r.Size
+	if size <= 0 {
+		size = defaultReservoirSize
+	}
+	r.seen++
+	r.sorted = false
+	if len(r.values) < size {
+		r.values = append(r.values, v)
+	} else if j := rand.Int63n(int64(r.seen)); j < int64(size) {
+		r.values[j] = v
+	}
+}
+
+// Count returns the number of values observed, including those not kept.
+func (r *Reservoir) Count() uint64 {
+	return r.seen
+}
+
+// Percentile returns the nearest-rank percentile, between 0 and 100, of the
+// values kept. It returns zero if there are none.
+func (r *Reservoir) Percentile(p float64) float64 {
+	if len(r.values) == 0 {
+		return 0
+	}
+	if !r.sorted {
+		sort.Float64s(r.values)
+		r.sorted = true
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(r.values))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(r.values) {
+		rank = len(r.values)
+	}
+	return r.values[rank-1]
+}
+
+// Reset forgets the values observed.
+func (r *Reservoir) Reset() {
+	r.seen = 0
+	r.values = nil
+	r.sorted = false
+}
+
+// PercentileSuffix formats a percentile as a metric name suffix, 99 becomes
+// "p99" and 99.9 becomes "p99_9". The percentile is rounded to 6 decimals to
+// hide floating point errors, such as 0.07*100 being 7.000000000000001.
+func PercentileSuffix(p float64) string {
+	s := strconv.FormatFloat(p, 'f', 6, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return "p" + strings.Replace(s, ".", "_", -1)
+}
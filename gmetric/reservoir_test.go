@@ -0,0 +1,62 @@
+package gmetric_test
+
+import (
+	"testing"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+func TestReservoirPercentile(t *testing.T) {
+	t.Parallel()
+	var r gmetric.Reservoir
+	if p := r.Percentile(50); p != 0 {
+		t.Fatalf("was expecting 0 without values but got %v", p)
+	}
+	for _, v := range []float64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10} {
+		r.Observe(v)
+	}
+	cases := map[float64]float64{0: 1, 10: 1, 50: 5, 95: 10, 99.9: 10, 100: 10}
+	for p, expected := range cases {
+		if actual := r.Percentile(p); actual != expected {
+			t.Fatalf("was expecting %v for p%v but got %v", expected, p, actual)
+		}
+	}
+	r.Reset()
+	if r.Count() != 0 || r.Percentile(50) != 0 {
+		t.Fatal("was expecting Reset to forget the values")
+	}
+}
+
+func TestReservoirSize(t *testing.T) {
+	t.Parallel()
+	r := gmetric.Reservoir{Size: 10}
+	for i := 0; i < 1000; i++ {
+		r.Observe(float64(i))
+	}
+	if r.Count() != 1000 {
+		t.Fatalf("was expecting 1000 observed values but got %d", r.Count())
+	}
+	if max := r.Percentile(100); max < 0 || max >= 1000 {
+		t.Fatalf("was expecting a sampled value but got %v", max)
+	}
+}
+
+func TestPercentileSuffix(t *testing.T) {
+	t.Parallel()
+	cases := map[float64]string{
+		50:   "p50",
+		7:    "p7",
+		99:   "p99",
+		99.9: "p99_9",
+		12.5: "p12_5",
+		100:  "p100",
+	}
+	for p, expected := range cases {
+		if actual := gmetric.PercentileSuffix(p); actual != expected {
+			t.Fatalf("was expecting %s for %v but got %s", expected, p, actual)
+		}
+	}
+	if actual := gmetric.PercentileSuffix(0.07 * 100); actual != "p7" {
+		t.Fatalf("was expecting p7 but got %s", actual)
+	}
+}
@@ -0,0 +1,137 @@
+package gmetric
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+type sentValue struct {
+	value   interface{}
+	number  float64
+	numeric bool
+	at      time.Time
+	expires time.Time
+}
+
+// The sent values are pruned once the map grows to this size, and from then
+// on whenever it doubles in size since the last prune.
+const minSentPrune = 64
+
+// The part of the TickInterval kept as a margin for timer jitter when
+// deriving the time threshold.
+const tickJitterDivisor = 10
+
+// Returns the effective TimeThreshold for the Metric, which defaults to and
+// is capped at the TickInterval less a tenth of it. The margin makes a value
+// written once per tick, slightly early because of timer jitter, still get
+// resent so the daemon receives an update within TMax. Returns zero if no
+// bound can be determined.
+func (m *Metric) timeThreshold(c *Client) time.Duration {
+	tick := m.TickInterval
+	if tick == 0 {
+		tick = c.TickInterval
+	}
+	limit := tick - tick/tickJitterDivisor
+	threshold := m.TimeThreshold
+	if threshold == 0 || (limit > 0 && threshold > limit) {
+		threshold = limit
+	}
+	return threshold
+}
+
+// Reports if the value should be sent based on the thresholds of the Metric,
+// and if so records it as the last sent value.
+func (c *Client) shouldSend(m *Metric, val interface{}) bool {
+	if m.ValueThreshold == 0 && m.TimeThreshold == 0 {
+		return true
+	}
+	// Without a time bound suppressing values could let the metric expire.
+	threshold := m.timeThreshold(c)
+	if threshold <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	cur := sentValue{value: val, at: now, expires: now.Add(threshold)}
+	cur.number, cur.numeric = toFloat64(val)
+	key := m.key(c)
+
+	c.sentMu.Lock()
+	defer c.sentMu.Unlock()
+	if c.sent == nil {
+		c.sent = make(map[string]sentValue)
+	}
+	last, ok := c.sent[key]
+	if ok && now.Sub(last.at) < threshold && !changed(last, cur, m.ValueThreshold) {
+		return false
+	}
+	c.sent[key] = cur
+	if len(c.sent) >= c.sentPrune {
+		c.pruneSent(now)
+	}
+	return true
+}
+
+// Drops the sent values whose threshold expired, as they no longer suppress
+// anything. This keeps names that are no longer written from accumulating.
+// Must be called with sentMu held.
+func (c *Client) pruneSent(now time.Time) {
+	for key, v := range c.sent {
+		if !now.Before(v.expires) {
+			delete(c.sent, key)
+		}
+	}
+	c.sentPrune = 2 * len(c.sent)
+	if c.sentPrune < minSentPrune {
+		c.sentPrune = minSentPrune
+	}
+}
+
+// Forgets the last sent value, ensuring the next value is sent.
+func (c *Client) forgetSent(m *Metric) {
+	c.sentMu.Lock()
+	delete(c.sent, m.key(c))
+	c.sentMu.Unlock()
+}
+
+func changed(last, cur sentValue, threshold float64) bool {
+	if last.numeric && cur.numeric {
+		return math.Abs(cur.number-last.number) > threshold
+	}
+	return fmt.Sprint(last.value) != fmt.Sprint(cur.value)
+}
+
+func toFloat64(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,30 @@
+package gmetric
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestThresholdPrunesExpiredValues(t *testing.T) {
+	t.Parallel()
+	c := &Client{Host: "localhost"}
+	m := &Metric{
+		ValueType:      ValueUint32,
+		TickInterval:   time.Hour,
+		ValueThreshold: 100,
+		TimeThreshold:  time.Millisecond,
+	}
+	for i := 0; i < minSentPrune; i++ {
+		m.Name = fmt.Sprintf("threshold_prune_metric_%d", i)
+		c.shouldSend(m, 1)
+	}
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < minSentPrune; i++ {
+		m.Name = fmt.Sprintf("threshold_prune_metric_%d", minSentPrune+i)
+		c.shouldSend(m, 1)
+	}
+	if len(c.sent) > minSentPrune {
+		t.Fatalf("expected expired values to be pruned, have %d", len(c.sent))
+	}
+}
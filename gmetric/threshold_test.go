@@ -0,0 +1,189 @@
+package gmetric_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+func writeValues(t *testing.T, c *gmetric.Client, m *gmetric.Metric, values ...interface{}) {
+	for _, v := range values {
+		if err := c.WriteValue(m, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkValues(t *testing.T, r *recorder, name string, expected ...string) {
+	if actual := r.Values(name); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("was expecting %v but got %v", expected, actual)
+	}
+}
+
+func TestValueThreshold(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	m := &gmetric.Metric{
+		Name:           "value_threshold_metric",
+		ValueType:      gmetric.ValueFloat64,
+		TickInterval:   time.Hour,
+		ValueThreshold: 1,
+	}
+	writeValues(t, c, m, 10, 10.5, 9.5, 12, 12, 10.9)
+	checkValues(t, r, m.Name, "10", "12", "10.9")
+}
+
+func TestValueThresholdParsesStrings(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	m := &gmetric.Metric{
+		Name:           "value_threshold_string_metric",
+		ValueType:      gmetric.ValueUint32,
+		TickInterval:   time.Hour,
+		ValueThreshold: 5,
+	}
+	writeValues(t, c, m, "10", "14", "16")
+	checkValues(t, r, m.Name, "10", "16")
+}
+
+func TestTimeThresholdOnlySendsChanges(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	m := &gmetric.Metric{
+		Name:          "time_threshold_change_metric",
+		ValueType:     gmetric.ValueString,
+		TickInterval:  time.Hour,
+		TimeThreshold: time.Hour,
+	}
+	writeValues(t, c, m, "a", "a", "b", "b", "a")
+	checkValues(t, r, m.Name, "a", "b", "a")
+}
+
+func TestTimeThresholdExpires(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	m := &gmetric.Metric{
+		Name:           "time_threshold_expires_metric",
+		ValueType:      gmetric.ValueUint32,
+		TickInterval:   time.Hour,
+		ValueThreshold: 100,
+		TimeThreshold:  20 * time.Millisecond,
+	}
+	writeValues(t, c, m, 1, 2)
+	time.Sleep(30 * time.Millisecond)
+	writeValues(t, c, m, 3)
+	checkValues(t, r, m.Name, "1", "3")
+}
+
+func TestTimeThresholdCappedToTickInterval(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	c.TickInterval = 20 * time.Millisecond
+	m := &gmetric.Metric{
+		Name:           "time_threshold_capped_metric",
+		ValueType:      gmetric.ValueUint32,
+		ValueThreshold: 100,
+		TimeThreshold:  time.Hour,
+	}
+	writeValues(t, c, m, 1, 1)
+	time.Sleep(30 * time.Millisecond)
+	writeValues(t, c, m, 1)
+	checkValues(t, r, m.Name, "1", "1")
+}
+
+func TestThresholdWithoutTickInterval(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	m := &gmetric.Metric{
+		Name:           "threshold_without_tick_metric",
+		ValueType:      gmetric.ValueUint32,
+		ValueThreshold: 100,
+	}
+	writeValues(t, c, m, 1, 1)
+	checkValues(t, r, m.Name, "1", "1")
+}
+
+func TestThresholdPerSpoofedHost(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	a := &gmetric.Metric{
+		Name:           "threshold_spoof_metric",
+		Spoof:          "10.0.0.1:a",
+		ValueType:      gmetric.ValueUint32,
+		TickInterval:   time.Hour,
+		ValueThreshold: 100,
+	}
+	b := *a
+	b.Spoof = "10.0.0.2:b"
+	writeValues(t, c, a, 1)
+	writeValues(t, c, &b, 1)
+	checkValues(t, r, a.Name, "1", "1")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("failed write")
+}
+
+func TestThresholdFailedWriteIsResent(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	m := &gmetric.Metric{
+		Name:           "threshold_failed_write_metric",
+		ValueType:      gmetric.ValueUint32,
+		TickInterval:   time.Hour,
+		ValueThreshold: 100,
+	}
+	c.Writer = failingWriter{}
+	errContains(t, c.WriteValue(m, 1), "failed write")
+	c.Writer = r
+	writeValues(t, c, m, 1)
+	checkValues(t, r, m.Name, "1")
+}
+
+func TestTimeThresholdResendsOnEarlyTick(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	const tick = 40 * time.Millisecond
+	c.TickInterval = tick
+	m := &gmetric.Metric{
+		Name:           "time_threshold_early_tick_metric",
+		ValueType:      gmetric.ValueUint32,
+		ValueThreshold: 100,
+		TimeThreshold:  time.Hour,
+	}
+	// Writing once per tick but slightly early, as a timer might, must not
+	// suppress the resends or the daemon would see gaps of two ticks.
+	for i := 0; i < 3; i++ {
+		writeValues(t, c, m, 1)
+		time.Sleep(tick - 2*time.Millisecond)
+	}
+	checkValues(t, r, m.Name, "1", "1", "1")
+}
+
+func TestValueThresholdSuppressesWithinTick(t *testing.T) {
+	t.Parallel()
+	c, r := newRecordingClient()
+	const tick = 100 * time.Millisecond
+	c.TickInterval = tick
+	m := &gmetric.Metric{
+		Name:           "value_threshold_within_tick_metric",
+		ValueType:      gmetric.ValueUint32,
+		ValueThreshold: 100,
+	}
+	// A collector running four times per tick only sends about once per tick.
+	start := time.Now()
+	for i := 0; i < 16; i++ {
+		writeValues(t, c, m, 1)
+		time.Sleep(tick / 4)
+	}
+	elapsed := time.Since(start)
+	sent := len(r.Values(m.Name))
+	if max := int(elapsed/(tick-tick/10)) + 1; sent < 2 || sent > max {
+		t.Fatalf("was expecting between 2 and %d sends over %s but got %d", max, elapsed, sent)
+	}
+}
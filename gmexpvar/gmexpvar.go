@@ -0,0 +1,189 @@
+// Package gmexpvar publishes expvar variables to ganglia.
+package gmexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// Rule configures the metrics for the variables it matches.
+type Rule struct {
+	// Match is a path.Match pattern matched against the flattened variable
+	// name, without the Prefix.
+	Match string
+
+	// Exclude skips the matching variables.
+	Exclude bool
+
+	// The units for the matching metrics.
+	Units string
+
+	// The groups for the matching metrics. If empty the default Groups of the
+	// Bridge are used.
+	Groups []string
+}
+
+// Bridge is a gmetric.Collector that provides the variables published via
+// expvar. Nested expvar.Map variables and JSON objects are flattened, joining
+// the keys with the Separator. Numeric and boolean values are published as
+// doubles.
+type Bridge struct {
+	// Prefix for all the metric names.
+	Prefix string
+
+	// Separator joins the keys of nested variables. Defaults to "_".
+	Separator string
+
+	// The default groups for the metrics.
+	Groups []string
+
+	// The rules are checked in order and the first matching one applies.
+	Rules []Rule
+
+	// ExcludeUnmatched skips the variables that do not match any rule,
+	// otherwise they are published with the defaults.
+	ExcludeUnmatched bool
+
+	// Strings publishes the non-numeric variables as string metrics, otherwise
+	// they are skipped.
+	Strings bool
+
+	mu      sync.Mutex
+	metrics map[string]*gmetric.Metric
+}
+
+var _ gmetric.Collector = (*Bridge)(nil)
+
+// Metrics returns the metrics discovered so far. The set of variables is
+// dynamic and new ones are discovered by Collect.
+func (b *Bridge) Metrics() []*gmetric.Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for name := range b.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]*gmetric.Metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, b.metrics[name])
+	}
+	return metrics
+}
+
+// Collect walks the expvar variables and returns a sample for each of the
+// included ones. Variables that cannot be decoded are reported in the
+// returned MultiError.
+func (b *Bridge) Collect() ([]gmetric.Sample, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var samples []gmetric.Sample
+	var errs gmetric.MultiError
+	expvar.Do(func(kv expvar.KeyValue) {
+		var v interface{}
+		d := json.NewDecoder(strings.NewReader(kv.Value.String()))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			errs = append(errs, fmt.Errorf("gmexpvar: invalid var %s: %s", kv.Key, err))
+			return
+		}
+		samples = b.flatten(samples, kv.Key, v)
+	})
+
+	if len(errs) == 0 {
+		return samples, nil
+	}
+	return samples, errs
+}
+
+func (b *Bridge) flatten(samples []gmetric.Sample, name string, v interface{}) []gmetric.Sample {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		sep := b.Separator
+		if sep == "" {
+			sep = "_"
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			samples = b.flatten(samples, name+sep+k, v[k])
+		}
+		return samples
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return samples
+		}
+		return b.sample(samples, name, f, true)
+	case bool:
+		if v {
+			return b.sample(samples, name, float64(1), true)
+		}
+		return b.sample(samples, name, float64(0), true)
+	case string:
+		if b.Strings {
+			return b.sample(samples, name, v, false)
+		}
+	}
+	// Arrays and nulls have no sensible metric representation.
+	return samples
+}
+
+func (b *Bridge) sample(samples []gmetric.Sample, name string, val interface{}, numeric bool) []gmetric.Sample {
+	rule, ok := b.rule(name)
+	if (ok && rule.Exclude) || (!ok && b.ExcludeUnmatched) {
+		return samples
+	}
+
+	m := b.metrics[name]
+	if m == nil || (m.ValueType == gmetric.ValueString) == numeric {
+		m = b.metric(name, rule, numeric)
+		if b.metrics == nil {
+			b.metrics = make(map[string]*gmetric.Metric)
+		}
+		b.metrics[name] = m
+	}
+	return append(samples, gmetric.Sample{Metric: m, Value: val})
+}
+
+func (b *Bridge) rule(name string) (*Rule, bool) {
+	for i := range b.Rules {
+		if matched, _ := path.Match(b.Rules[i].Match, name); matched {
+			return &b.Rules[i], true
+		}
+	}
+	return nil, false
+}
+
+func (b *Bridge) metric(name string, rule *Rule, numeric bool) *gmetric.Metric {
+	m := &gmetric.Metric{
+		Name:      b.Prefix + name,
+		Title:     name,
+		Groups:    b.Groups,
+		ValueType: gmetric.ValueFloat64,
+		Slope:     gmetric.SlopeBoth,
+	}
+	if !numeric {
+		m.ValueType = gmetric.ValueString
+		m.Slope = gmetric.SlopeZero
+	}
+	if rule != nil {
+		m.Units = rule.Units
+		if len(rule.Groups) > 0 {
+			m.Groups = rule.Groups
+		}
+	}
+	return m
+}
@@ -0,0 +1,140 @@
+package gmexpvar_test
+
+import (
+	"expvar"
+	"reflect"
+	"testing"
+
+	"github.com/facebookgo/ganglia/gmetric"
+	"github.com/facebookgo/ganglia/gmexpvar"
+)
+
+func init() {
+	expvar.NewInt("gmexpvar_test_requests").Set(42)
+	expvar.NewInt("gmexpvar_test_skip_me").Set(1)
+	expvar.NewString("gmexpvar_test_version").Set("v1")
+
+	m := expvar.NewMap("gmexpvar_test_map")
+	m.Add("hits", 3)
+	inner := new(expvar.Map).Init()
+	latency := new(expvar.Float)
+	latency.Set(1.5)
+	inner.Set("latency", latency)
+	m.Set("inner", inner)
+
+	expvar.Publish("gmexpvar_test_func", expvar.Func(func() interface{} {
+		return struct {
+			Up    bool
+			Count int
+			List  []int
+		}{true, 7, []int{1, 2}}
+	}))
+}
+
+func collect(t *testing.T, b *gmexpvar.Bridge) map[string]gmetric.Sample {
+	samples, err := b.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]gmetric.Sample)
+	for _, s := range samples {
+		byName[s.Metric.Name] = s
+	}
+	return byName
+}
+
+func TestBridge(t *testing.T) {
+	t.Parallel()
+	b := &gmexpvar.Bridge{
+		Prefix: "app_",
+		Groups: []string{"app"},
+		Rules: []gmexpvar.Rule{
+			{Match: "gmexpvar_test_skip*", Exclude: true},
+			{Match: "gmexpvar_test_map_*", Units: "ms", Groups: []string{"map"}},
+			{Match: "gmexpvar_test_*", Units: "count"},
+		},
+		ExcludeUnmatched: true,
+	}
+	samples := collect(t, b)
+
+	expected := map[string]float64{
+		"app_gmexpvar_test_requests":          42,
+		"app_gmexpvar_test_map_hits":          3,
+		"app_gmexpvar_test_map_inner_latency": 1.5,
+		"app_gmexpvar_test_func_Up":           1,
+		"app_gmexpvar_test_func_Count":        7,
+	}
+	if len(samples) != len(expected) {
+		t.Fatalf("was expecting %d samples but got %v", len(expected), samples)
+	}
+	for name, val := range expected {
+		s, ok := samples[name]
+		if !ok {
+			t.Fatalf("missing sample for %s", name)
+		}
+		if s.Value != val {
+			t.Fatalf("was expecting %v for %s but got %v", val, name, s.Value)
+		}
+		if s.Metric.ValueType != gmetric.ValueFloat64 {
+			t.Fatalf("was expecting a double for %s but got %s", name, s.Metric.ValueType)
+		}
+	}
+
+	hits := samples["app_gmexpvar_test_map_hits"].Metric
+	if hits.Units != "ms" || !reflect.DeepEqual(hits.Groups, []string{"map"}) {
+		t.Fatalf("unexpected metric %+v", hits)
+	}
+	requests := samples["app_gmexpvar_test_requests"].Metric
+	if requests.Units != "count" || !reflect.DeepEqual(requests.Groups, []string{"app"}) {
+		t.Fatalf("unexpected metric %+v", requests)
+	}
+
+	// The same Metric is reused across collections.
+	if again := collect(t, b); again["app_gmexpvar_test_requests"].Metric != requests {
+		t.Fatal("was expecting the metric to be reused")
+	}
+	if len(b.Metrics()) != len(expected) {
+		t.Fatalf("was expecting %d metrics but got %d", len(expected), len(b.Metrics()))
+	}
+}
+
+func TestBridgeStrings(t *testing.T) {
+	t.Parallel()
+	b := &gmexpvar.Bridge{
+		Rules:            []gmexpvar.Rule{{Match: "gmexpvar_test_version"}},
+		ExcludeUnmatched: true,
+		Strings:          true,
+	}
+	samples := collect(t, b)
+	s, ok := samples["gmexpvar_test_version"]
+	if !ok {
+		t.Fatalf("missing string sample in %v", samples)
+	}
+	if s.Value != "v1" || s.Metric.ValueType != gmetric.ValueString {
+		t.Fatalf("unexpected sample %+v", s)
+	}
+}
+
+func TestBridgeSkipsStrings(t *testing.T) {
+	t.Parallel()
+	b := &gmexpvar.Bridge{
+		Rules:            []gmexpvar.Rule{{Match: "gmexpvar_test_version"}},
+		ExcludeUnmatched: true,
+	}
+	if samples := collect(t, b); len(samples) != 0 {
+		t.Fatalf("was expecting no samples but got %v", samples)
+	}
+}
+
+func TestBridgeSeparator(t *testing.T) {
+	t.Parallel()
+	b := &gmexpvar.Bridge{
+		Separator:        ".",
+		Rules:            []gmexpvar.Rule{{Match: "gmexpvar_test_map.*"}},
+		ExcludeUnmatched: true,
+	}
+	samples := collect(t, b)
+	if _, ok := samples["gmexpvar_test_map.inner.latency"]; !ok {
+		t.Fatalf("missing nested sample in %v", samples)
+	}
+}
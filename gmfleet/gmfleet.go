@@ -0,0 +1,325 @@
+// Package gmfleet publishes metrics on behalf of devices that cannot run
+// gmond, each appearing in ganglia as its own spoofed host.
+package gmfleet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+var (
+	errNoClient      = errors.New("gmfleet: no client provided")
+	errNoCollector   = errors.New("gmfleet: host has no collector")
+	errInvalidHost   = errors.New("gmfleet: host needs an IP and a name")
+	errNotStarted    = errors.New("gmfleet: fleet not started")
+	errAlreadyActive = errors.New("gmfleet: fleet already started")
+)
+
+// The default interval between heartbeats, the same as gmond.
+const defaultHeartbeatInterval = 20 * time.Second
+
+// The DMax sent for the metrics of a removed host, so the daemon expires
+// them promptly.
+const removedLifetime = time.Second
+
+// Host is a virtual host, such as a network device.
+type Host struct {
+	// The IP address and name the host appears as, sent as the "IP:name"
+	// spoof.
+	IP   string
+	Name string
+
+	// The Collector providing the metrics of the host. The metrics are spoofed
+	// to the host, overriding any Spoof they have.
+	Collector gmetric.Collector
+
+	// How often the Collector is run. Defaults to the TickInterval of the
+	// Client.
+	Every time.Duration
+}
+
+// Spoof returns the spoof for the host in the "IP:name" form.
+func (h Host) Spoof() string {
+	return h.IP + ":" + h.Name
+}
+
+// Fleet manages a table of virtual hosts, running the collection and sending
+// the heartbeats of each one. Hosts may be added and removed at any time.
+type Fleet struct {
+	// The Client the metrics are written to.
+	Client *gmetric.Client
+
+	// How often a heartbeat is sent for each host. Defaults to 20 seconds.
+	HeartbeatInterval time.Duration
+
+	// Bounds how long a single Collect may take. See gmetric.Scheduler.
+	Timeout time.Duration
+
+	// Also known as send_metadata_interval. See gmetric.Scheduler.
+	MetaInterval time.Duration
+
+	// Optional handler for errors from the Collectors and the Client. Errors
+	// are wrapped to include the host.
+	ErrorHandler func(error)
+
+	mu      sync.Mutex
+	started bool
+	hosts   map[string]*member
+}
+
+type member struct {
+	host      Host
+	collector *spoofCollector
+	scheduler *gmetric.Scheduler
+}
+
+// Add a host, replacing any host with the same spoof. If the Fleet is started
+// the host starts being published immediately.
+func (f *Fleet) Add(h Host) error {
+	if h.IP == "" || h.Name == "" {
+		return errInvalidHost
+	}
+	if h.Collector == nil {
+		return errNoCollector
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hosts == nil {
+		f.hosts = make(map[string]*member)
+	}
+	if old, ok := f.hosts[h.Spoof()]; ok {
+		f.stop(old, false)
+	}
+	m := f.newMember(h)
+	f.hosts[h.Spoof()] = m
+	if f.started {
+		return f.start(m)
+	}
+	return nil
+}
+
+// Remove the host with the given spoof. The metrics returned by its latest
+// collection are resent with a short lifetime so the daemon expires them, and its heartbeats stop so the
+// daemon eventually expires the host itself, based on its host_dmax.
+func (f *Fleet) Remove(spoof string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.hosts[spoof]
+	if !ok {
+		return fmt.Errorf("gmfleet: unknown host %s", spoof)
+	}
+	delete(f.hosts, spoof)
+	return f.stop(m, true)
+}
+
+// Hosts returns the spoofs of the hosts, sorted.
+func (f *Fleet) Hosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	spoofs := make([]string, 0, len(f.hosts))
+	for spoof := range f.hosts {
+		spoofs = append(spoofs, spoof)
+	}
+	sort.Strings(spoofs)
+	return spoofs
+}
+
+// Start publishing the hosts. If an error is returned it will be a
+// gmetric.MultiError.
+func (f *Fleet) Start() error {
+	if f.Client == nil {
+		return errNoClient
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.started {
+		return errAlreadyActive
+	}
+	f.started = true
+	var errs gmetric.MultiError
+	for _, m := range f.hosts {
+		if err := f.start(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Stop publishing the hosts. The hosts are kept, and are not expired in the
+// daemon.
+func (f *Fleet) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.started {
+		return errNotStarted
+	}
+	f.started = false
+	for _, m := range f.hosts {
+		f.stop(m, false)
+	}
+	return nil
+}
+
+func (f *Fleet) newMember(h Host) *member {
+	return &member{
+		host: h,
+		collector: &spoofCollector{
+			collector: h.Collector,
+			spoof:     h.Spoof(),
+			metrics:   make(map[string]*spoofedMetric),
+		},
+	}
+}
+
+func (f *Fleet) start(m *member) error {
+	heartbeat := f.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeatInterval
+	}
+	spoof := m.host.Spoof()
+	s := &gmetric.Scheduler{
+		Client:       f.Client,
+		Timeout:      f.Timeout,
+		MetaInterval: f.MetaInterval,
+		ErrorHandler: func(err error) {
+			f.error(fmt.Errorf("gmfleet: host %s: %s", spoof, err))
+		},
+	}
+	s.Add(&heartbeatCollector{metric: gmetric.HeartbeatMetric(spoof)}, heartbeat)
+	s.Add(m.collector, m.host.Every)
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("gmfleet: host %s: %s", spoof, err)
+	}
+	m.scheduler = s
+	return nil
+}
+
+// Stops the scheduler of the member, and optionally expires its metrics.
+func (f *Fleet) stop(m *member, expire bool) error {
+	if m.scheduler != nil {
+		m.scheduler.Stop()
+		m.scheduler = nil
+	}
+	if !expire || f.Client == nil {
+		return nil
+	}
+	var errs gmetric.MultiError
+	for _, metric := range m.collector.spoofed() {
+		expired := *metric
+		expired.Lifetime = removedLifetime
+		if err := f.Client.WriteMeta(&expired); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (f *Fleet) error(err error) {
+	if f.ErrorHandler != nil {
+		f.ErrorHandler(err)
+	}
+}
+
+// spoofCollector spoofs the metrics of a Collector, keeping a stable spoofed
+// copy of every Metric so the thresholds and metadata are tracked. The copies
+// are kept by name, and those missing from a collection are dropped.
+type spoofCollector struct {
+	collector gmetric.Collector
+	spoof     string
+
+	mu      sync.Mutex
+	metrics map[string]*spoofedMetric
+}
+
+type spoofedMetric struct {
+	source    *gmetric.Metric
+	spoofed   *gmetric.Metric
+	collected bool
+}
+
+func (s *spoofCollector) Metrics() []*gmetric.Metric {
+	var metrics []*gmetric.Metric
+	for _, m := range s.collector.Metrics() {
+		metrics = append(metrics, s.spoofMetric(m))
+	}
+	return metrics
+}
+
+// Collect spoofs the samples of the Collector. Unless the collection failed
+// without samples, the copies of the metrics it did not return are dropped.
+func (s *spoofCollector) Collect() ([]gmetric.Sample, error) {
+	samples, err := s.collector.Collect()
+	if err != nil && len(samples) == 0 {
+		return samples, err
+	}
+	names := make(map[string]bool, len(samples))
+	for i, sample := range samples {
+		if sample.Metric != nil {
+			samples[i].Metric = s.spoofMetric(sample.Metric)
+			names[sample.Metric.Name] = true
+		}
+	}
+	s.mu.Lock()
+	for name, m := range s.metrics {
+		if names[name] {
+			m.collected = true
+		} else {
+			delete(s.metrics, name)
+		}
+	}
+	s.mu.Unlock()
+	return samples, err
+}
+
+// Returns the spoofed copy of the Metric, made anew if the Collector
+// provides a different Metric for the name.
+func (s *spoofCollector) spoofMetric(m *gmetric.Metric) *gmetric.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.metrics[m.Name]; ok && cached.source == m {
+		return cached.spoofed
+	}
+	spoofed := *m
+	spoofed.Spoof = s.spoof
+	spoofed.Host = ""
+	s.metrics[m.Name] = &spoofedMetric{source: m, spoofed: &spoofed}
+	return &spoofed
+}
+
+// Returns the spoofed metrics returned by the latest collection.
+func (s *spoofCollector) spoofed() []*gmetric.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metrics := make([]*gmetric.Metric, 0, len(s.metrics))
+	for _, m := range s.metrics {
+		if m.collected {
+			metrics = append(metrics, m.spoofed)
+		}
+	}
+	return metrics
+}
+
+// heartbeatCollector provides the heartbeat of a host.
+type heartbeatCollector struct {
+	metric *gmetric.Metric
+}
+
+func (h *heartbeatCollector) Metrics() []*gmetric.Metric {
+	return []*gmetric.Metric{h.metric}
+}
+
+func (h *heartbeatCollector) Collect() ([]gmetric.Sample, error) {
+	return []gmetric.Sample{{Metric: h.metric, Value: 0}}, nil
+}
@@ -0,0 +1,214 @@
+package gmfleet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// packetWriter records the packets written to it.
+type packetWriter struct {
+	mu      sync.Mutex
+	packets [][]byte
+}
+
+func (w *packetWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	w.packets = append(w.packets, append([]byte(nil), b...))
+	w.mu.Unlock()
+	return len(b), nil
+}
+
+func (w *packetWriter) count(substrings ...string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for _, p := range w.packets {
+		matched := true
+		for _, s := range substrings {
+			if !bytes.Contains(p, []byte(s)) {
+				matched = false
+			}
+		}
+		if matched {
+			n++
+		}
+	}
+	return n
+}
+
+func (w *packetWriter) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.packets)
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type deviceCollector struct {
+	metric *gmetric.Metric
+}
+
+func (d *deviceCollector) Metrics() []*gmetric.Metric {
+	return []*gmetric.Metric{d.metric}
+}
+
+func (d *deviceCollector) Collect() ([]gmetric.Sample, error) {
+	return []gmetric.Sample{{Metric: d.metric, Value: 42}}, nil
+}
+
+func newDevice() *deviceCollector {
+	return &deviceCollector{metric: &gmetric.Metric{
+		Name:      "if_octets",
+		ValueType: gmetric.ValueUint32,
+		Slope:     gmetric.SlopeBoth,
+	}}
+}
+
+func TestFleet(t *testing.T) {
+	t.Parallel()
+	w := &packetWriter{}
+	f := &Fleet{
+		Client:            &gmetric.Client{Writer: w, Host: "collector"},
+		HeartbeatInterval: 10 * time.Millisecond,
+	}
+	device := newDevice()
+	if err := f.Add(Host{IP: "10.0.0.1", Name: "router1", Collector: device, Every: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add(Host{IP: "10.0.0.2", Name: "switch1", Collector: newDevice(), Every: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if hosts := f.Hosts(); strings.Join(hosts, ",") != "10.0.0.1:router1,10.0.0.2:switch1" {
+		t.Fatalf("unexpected hosts %v", hosts)
+	}
+
+	waitFor(t, "the spoofed metrics and heartbeats", func() bool {
+		return w.count("10.0.0.1:router1", "if_octets") >= 2 &&
+			w.count("10.0.0.2:switch1", "heartbeat") >= 2
+	})
+	if device.metric.Spoof != "" {
+		t.Fatal("was not expecting the original metric to be modified")
+	}
+
+	if err := f.Remove("10.0.0.1:router1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Remove("10.0.0.1:router1"); err == nil {
+		t.Fatal("was expecting an error removing an unknown host")
+	}
+	removed := w.count("10.0.0.1:router1")
+	time.Sleep(50 * time.Millisecond)
+	if n := w.count("10.0.0.1:router1"); n != removed {
+		t.Fatalf("was not expecting writes for the removed host but got %d more", n-removed)
+	}
+	waitFor(t, "the remaining host", func() bool {
+		return w.count("10.0.0.2:switch1", "if_octets") >= 4
+	})
+
+	if err := f.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	stopped := w.len()
+	time.Sleep(50 * time.Millisecond)
+	if w.len() != stopped {
+		t.Fatal("was not expecting writes after Stop")
+	}
+}
+
+// renamingCollector returns new metrics with new names on every Collect.
+type renamingCollector struct {
+	n int
+}
+
+func (r *renamingCollector) Metrics() []*gmetric.Metric { return nil }
+
+func (r *renamingCollector) Collect() ([]gmetric.Sample, error) {
+	r.n++
+	return []gmetric.Sample{
+		{Metric: &gmetric.Metric{Name: "up", ValueType: gmetric.ValueUint32}, Value: 1},
+		{Metric: &gmetric.Metric{Name: fmt.Sprintf("port_%d", r.n), ValueType: gmetric.ValueUint32}, Value: 1},
+	}, nil
+}
+
+func TestSpoofCollectorPrunes(t *testing.T) {
+	t.Parallel()
+	s := &spoofCollector{
+		collector: &renamingCollector{},
+		spoof:     "10.0.0.1:a",
+		metrics:   make(map[string]*spoofedMetric),
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.Collect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var names []string
+	for _, m := range s.spoofed() {
+		if m.Spoof != "10.0.0.1:a" {
+			t.Fatalf("was expecting %s to be spoofed", m.Name)
+		}
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "port_3,up" {
+		t.Fatalf("was expecting only the latest metrics but got %v", names)
+	}
+}
+
+type failingCollector struct{}
+
+func (failingCollector) Metrics() []*gmetric.Metric { return nil }
+
+func (failingCollector) Collect() ([]gmetric.Sample, error) {
+	return nil, errors.New("device unreachable")
+}
+
+func TestFleetErrors(t *testing.T) {
+	t.Parallel()
+	errs := make(chan error, 10)
+	f := &Fleet{
+		Client: &gmetric.Client{Writer: &packetWriter{}, Host: "collector"},
+		ErrorHandler: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	}
+	if err := f.Add(Host{IP: "10.0.0.1", Collector: failingCollector{}}); err != errInvalidHost {
+		t.Fatalf("was expecting errInvalidHost but got %v", err)
+	}
+	if err := f.Add(Host{IP: "10.0.0.1", Name: "a"}); err != errNoCollector {
+		t.Fatalf("was expecting errNoCollector but got %v", err)
+	}
+	if err := f.Add(Host{IP: "10.0.0.1", Name: "a", Collector: failingCollector{}, Every: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	err := <-errs
+	if !strings.Contains(err.Error(), "10.0.0.1:a") || !strings.Contains(err.Error(), "device unreachable") {
+		t.Fatalf("was expecting the host and cause in the error but got %s", err)
+	}
+}
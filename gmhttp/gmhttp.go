@@ -0,0 +1,287 @@
+// Package gmhttp instruments net/http handlers and publishes the request
+// metrics to ganglia.
+package gmhttp
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// The default percentiles published for the request latency.
+var defaultPercentiles = []float64{50, 95, 99}
+
+// Instrumenter wraps http.Handlers to record, per route, the request rate,
+// in-flight requests, latency percentiles, response size and the rate of
+// responses by status class. It is a gmetric.Collector, and each route is
+// published into its own group named "<prefix>_<route>" with metrics such as
+// "<prefix>_<route>_requests" and "<prefix>_<route>_latency_p99".
+type Instrumenter struct {
+	// Prefix for the metric names. Defaults to "http".
+	Prefix string
+
+	// The latency percentiles to publish. Defaults to 50, 95 and 99.
+	Percentiles []float64
+
+	// The number of latencies kept per interval to derive the percentiles.
+	// Defaults to 1024.
+	ReservoirSize int
+
+	mu     sync.Mutex
+	now    func() time.Time
+	routes map[string]*route
+	order  []string
+}
+
+var _ gmetric.Collector = (*Instrumenter)(nil)
+
+type route struct {
+	inflight int64 // accessed atomically
+
+	requestsMetric *gmetric.Metric
+	inflightMetric *gmetric.Metric
+	sizeMetric     *gmetric.Metric
+	statusMetrics  [5]*gmetric.Metric
+	latencyMetrics []*gmetric.Metric
+
+	mu        sync.Mutex
+	since     time.Time
+	requests  uint64
+	bytes     uint64
+	statuses  [5]uint64
+	latencies gmetric.Reservoir
+}
+
+// Handler returns a http.Handler that records metrics for the given route.
+// The route becomes part of the metric names and should only contain
+// characters safe for a file name, like "login" or "api_users".
+func (i *Instrumenter) Handler(name string, h http.Handler) http.Handler {
+	r := i.route(name)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&r.inflight, 1)
+		start := i.clock()
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			atomic.AddInt64(&r.inflight, -1)
+			// A panic is recorded as a server error, whatever the handler
+			// wrote before it, and then passed on to the server.
+			p := recover()
+			if p != nil {
+				rw.status = http.StatusInternalServerError
+			}
+			i.record(r, rw, i.clock().Sub(start))
+			if p != nil {
+				panic(p)
+			}
+		}()
+		h.ServeHTTP(rw.wrap(), req)
+	})
+}
+
+func (i *Instrumenter) clock() time.Time {
+	if i.now != nil {
+		return i.now()
+	}
+	return time.Now()
+}
+
+func (i *Instrumenter) route(name string) *route {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if r, ok := i.routes[name]; ok {
+		return r
+	}
+
+	prefix := i.Prefix
+	if prefix == "" {
+		prefix = "http"
+	}
+	base := prefix + "_" + name
+	groups := []string{base}
+	metric := func(suffix, title, units string) *gmetric.Metric {
+		return &gmetric.Metric{
+			Name:      base + "_" + suffix,
+			Title:     title + " (" + name + ")",
+			Units:     units,
+			Groups:    groups,
+			ValueType: gmetric.ValueFloat64,
+			Slope:     gmetric.SlopeBoth,
+		}
+	}
+	r := &route{
+		since:          i.clock(),
+		requestsMetric: metric("requests", "Requests", "requests/sec"),
+		inflightMetric: metric("inflight", "In-flight Requests", "requests"),
+		sizeMetric:     metric("response_bytes", "Average Response Size", "bytes"),
+	}
+	r.inflightMetric.ValueType = gmetric.ValueUint32
+	for class := range r.statusMetrics {
+		code := strconv.Itoa(class+1) + "xx"
+		r.statusMetrics[class] = metric(code, code+" Responses", "requests/sec")
+	}
+	percentiles := i.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = defaultPercentiles
+	}
+	for _, p := range percentiles {
+		suffix := gmetric.PercentileSuffix(p)
+		r.latencyMetrics = append(
+			r.latencyMetrics,
+			metric("latency_"+suffix, suffix+" Latency", "ms"),
+		)
+	}
+
+	if i.routes == nil {
+		i.routes = make(map[string]*route)
+	}
+	i.routes[name] = r
+	i.order = append(i.order, name)
+	sort.Strings(i.order)
+	return r
+}
+
+func (i *Instrumenter) record(r *route, rw *responseWriter, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	r.bytes += uint64(rw.size)
+	status := rw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if class := status/100 - 1; class >= 0 && class < len(r.statuses) {
+		r.statuses[class]++
+	}
+	r.latencies.Size = i.ReservoirSize
+	r.latencies.Observe(latency.Seconds() * 1000)
+}
+
+// Metrics returns the metrics for the routes wrapped so far.
+func (i *Instrumenter) Metrics() []*gmetric.Metric {
+	var metrics []*gmetric.Metric
+	for _, r := range i.sortedRoutes() {
+		metrics = append(metrics, r.requestsMetric, r.inflightMetric, r.sizeMetric)
+		metrics = append(metrics, r.statusMetrics[:]...)
+		metrics = append(metrics, r.latencyMetrics...)
+	}
+	return metrics
+}
+
+// Collect returns the metrics for the requests completed since the last call
+// to Collect.
+func (i *Instrumenter) Collect() ([]gmetric.Sample, error) {
+	percentiles := i.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = defaultPercentiles
+	}
+
+	now := i.clock()
+	var samples []gmetric.Sample
+	for _, r := range i.sortedRoutes() {
+		r.mu.Lock()
+		elapsed := now.Sub(r.since).Seconds()
+		requests, bytes, statuses := r.requests, r.bytes, r.statuses
+		latencies := r.latencies
+		r.since = now
+		r.requests, r.bytes, r.statuses = 0, 0, [5]uint64{}
+		r.latencies = gmetric.Reservoir{}
+		r.mu.Unlock()
+
+		samples = append(samples, gmetric.Sample{
+			Metric: r.inflightMetric,
+			Value:  atomic.LoadInt64(&r.inflight),
+		})
+		if elapsed <= 0 {
+			continue
+		}
+
+		samples = append(samples, gmetric.Sample{
+			Metric: r.requestsMetric,
+			Value:  float64(requests) / elapsed,
+		})
+		for class, count := range statuses {
+			samples = append(samples, gmetric.Sample{
+				Metric: r.statusMetrics[class],
+				Value:  float64(count) / elapsed,
+			})
+		}
+		var avg float64
+		if requests > 0 {
+			avg = float64(bytes) / float64(requests)
+		}
+		samples = append(samples, gmetric.Sample{Metric: r.sizeMetric, Value: avg})
+
+		for j, p := range percentiles {
+			samples = append(samples, gmetric.Sample{
+				Metric: r.latencyMetrics[j],
+				Value:  latencies.Percentile(p),
+			})
+		}
+	}
+	return samples, nil
+}
+
+func (i *Instrumenter) sortedRoutes() []*route {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	routes := make([]*route, 0, len(i.order))
+	for _, name := range i.order {
+		routes = append(routes, i.routes[name])
+	}
+	return routes
+}
+
+// responseWriter records the status and size of the response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Returns the responseWriter exposing http.Flusher and http.Hijacker only
+// if the underlying ResponseWriter implements them, so handlers checking for
+// them see the same capabilities as without the instrumentation.
+func (w *responseWriter) wrap() http.ResponseWriter {
+	f, isFlusher := w.ResponseWriter.(http.Flusher)
+	h, isHijacker := w.ResponseWriter.(http.Hijacker)
+	switch {
+	case isFlusher && isHijacker:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, f, h}
+	case isFlusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{w, f}
+	case isHijacker:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{w, h}
+	}
+	return w
+}
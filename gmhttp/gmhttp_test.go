@@ -0,0 +1,187 @@
+package gmhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func values(t *testing.T, i *Instrumenter) map[string]interface{} {
+	samples, err := i.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := make(map[string]interface{})
+	for _, s := range samples {
+		v[s.Metric.Name] = s.Value
+	}
+	return v
+}
+
+func checkValue(t *testing.T, values map[string]interface{}, name string, expected interface{}) {
+	actual, ok := values[name]
+	if !ok {
+		t.Fatalf("missing value for %s in %v", name, values)
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("was expecting %v for %s but got %v", expected, name, actual)
+	}
+}
+
+func TestInstrumenter(t *testing.T) {
+	t.Parallel()
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	i := &Instrumenter{now: clock.Now}
+	h := i.Handler("login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms, _ := strconv.Atoi(r.FormValue("ms"))
+		clock.now = clock.now.Add(time.Duration(ms) * time.Millisecond)
+		if status, _ := strconv.Atoi(r.FormValue("status")); status != 0 {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte("hello"))
+	}))
+
+	statuses := []int{0, 200, 201, 200, 200, 302, 200, 404, 404, 500}
+	for n, status := range statuses {
+		url := fmt.Sprintf("/login?ms=%d&status=%d", n+1, status)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	clock.now = clock.now.Add(10*time.Second - 55*time.Millisecond)
+
+	v := values(t, i)
+	checkValue(t, v, "http_login_requests", 1)
+	checkValue(t, v, "http_login_inflight", 0)
+	checkValue(t, v, "http_login_1xx", 0)
+	checkValue(t, v, "http_login_2xx", 0.6)
+	checkValue(t, v, "http_login_3xx", 0.1)
+	checkValue(t, v, "http_login_4xx", 0.2)
+	checkValue(t, v, "http_login_5xx", 0.1)
+	checkValue(t, v, "http_login_response_bytes", 5)
+	checkValue(t, v, "http_login_latency_p50", 5)
+	checkValue(t, v, "http_login_latency_p95", 10)
+	checkValue(t, v, "http_login_latency_p99", 10)
+
+	// The counters are reset on each Collect.
+	clock.now = clock.now.Add(10 * time.Second)
+	v = values(t, i)
+	checkValue(t, v, "http_login_requests", 0)
+	checkValue(t, v, "http_login_latency_p50", 0)
+}
+
+func TestInstrumenterInflight(t *testing.T) {
+	t.Parallel()
+	i := &Instrumenter{Prefix: "web"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := i.Handler("slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	<-started
+	checkValue(t, values(t, i), "web_slow_inflight", 1)
+	close(release)
+	<-done
+	checkValue(t, values(t, i), "web_slow_inflight", 0)
+}
+
+func TestInstrumenterMetrics(t *testing.T) {
+	t.Parallel()
+	i := &Instrumenter{Percentiles: []float64{99.9}}
+	i.Handler("b", http.NotFoundHandler())
+	i.Handler("a", http.NotFoundHandler())
+	i.Handler("a", http.NotFoundHandler())
+	metrics := i.Metrics()
+	if len(metrics) != 2*9 {
+		t.Fatalf("was expecting 18 metrics but got %d", len(metrics))
+	}
+	expected := []string{"http_a_requests", "http_b_requests"}
+	if metrics[0].Name != expected[0] || metrics[9].Name != expected[1] {
+		t.Fatalf("was expecting routes in sorted order but got %s, %s", metrics[0].Name, metrics[9].Name)
+	}
+	last := metrics[len(metrics)-1]
+	if last.Name != "http_b_latency_p99_9" || last.Groups[0] != "http_b" {
+		t.Fatalf("unexpected metric %+v", last)
+	}
+	if metrics[1].ValueType != gmetric.ValueUint32 {
+		t.Fatalf("was expecting uint32 for inflight but got %s", metrics[1].ValueType)
+	}
+}
+
+func TestResponseWriterFlush(t *testing.T) {
+	t.Parallel()
+	i := &Instrumenter{}
+	h := i.Handler("flush", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Fatal("was expecting the response to be flushed")
+	}
+}
+
+func TestResponseWriterHijackUnsupported(t *testing.T) {
+	t.Parallel()
+	i := &Instrumenter{}
+	h := i.Handler("hijack", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Hijacker); ok {
+			t.Fatal("was not expecting a Hijacker")
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			t.Fatal("was expecting a Flusher")
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestResponseWriterNoFlusher(t *testing.T) {
+	t.Parallel()
+	i := &Instrumenter{}
+	h := i.Handler("noflush", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); ok {
+			t.Fatal("was not expecting a Flusher")
+		}
+	}))
+	h.ServeHTTP(struct{ http.ResponseWriter }{httptest.NewRecorder()}, httptest.NewRequest("GET", "/", nil))
+}
+
+func TestInstrumenterPanic(t *testing.T) {
+	t.Parallel()
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	i := &Instrumenter{now: clock.Now}
+	h := i.Handler("panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler panic")
+	}))
+	func() {
+		defer func() {
+			if r := recover(); r != "handler panic" {
+				t.Fatalf("was expecting the panic to be passed on but got %v", r)
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	clock.now = clock.now.Add(time.Second)
+
+	v := values(t, i)
+	checkValue(t, v, "http_panic_2xx", 0)
+	checkValue(t, v, "http_panic_5xx", 1)
+	checkValue(t, v, "http_panic_inflight", 0)
+}
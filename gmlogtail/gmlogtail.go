@@ -0,0 +1,557 @@
+// Package gmlogtail follows log files and publishes metrics derived from the
+// lines matching a set of rules to ganglia, much like ganglia-logtailer.
+package gmlogtail
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+var errNoPattern = errors.New("gmlogtail: rule has no pattern")
+
+// The number of bytes at the start of a file used to recognize it after a
+// restart.
+const fingerprintSize = 1024
+
+// The default percentiles published for distributions.
+var defaultPercentiles = []float64{50, 95, 99}
+
+// Kind defines how the lines matching a Rule are turned into metrics.
+type Kind int
+
+const (
+	// Counter publishes the total number of matching lines since start.
+	Counter Kind = iota
+
+	// Rate publishes the number of matching lines per second.
+	Rate
+
+	// Distribution publishes the average, minimum, maximum and percentiles of
+	// a value captured from the matching lines, as "<name>_avg",
+	// "<name>_min", "<name>_max" and "<name>_p99" and so on.
+	Distribution
+)
+
+// Rule matches lines and defines the metrics derived from them.
+type Rule struct {
+	// The metric name, or the prefix for the metric names of a Distribution.
+	Name string
+
+	// The lines matching the pattern are counted.
+	Pattern *regexp.Regexp
+
+	// Defines the kind of metric.
+	Kind Kind
+
+	// The named submatch containing the value for a Distribution. Defaults to
+	// the first submatch.
+	Value string
+
+	// The percentiles published for a Distribution. Defaults to 50, 95 and 99.
+	Percentiles []float64
+
+	// The units for the metrics.
+	Units string
+
+	// The groups for the metrics. Defaults to the Groups of the Tailer.
+	Groups []string
+}
+
+// Tailer follows a set of log files, through rotation and truncation, and is
+// a gmetric.Collector providing the metrics defined by its rules. New lines
+// are read on each call to Collect. Files without a saved offset are
+// followed from their end, and files that are replaced by rotation are read
+// from the start.
+type Tailer struct {
+	// The log files to follow.
+	Paths []string
+
+	// The rules every line is matched against. A line may match many rules.
+	Rules []Rule
+
+	// The default groups for the metrics.
+	Groups []string
+
+	// Optional file the offsets are saved to after every Collect, allowing
+	// a restarted Tailer to continue where it left off.
+	StateFile string
+
+	// The number of values kept per interval to derive the percentiles of a
+	// Distribution. Defaults to 1024.
+	ReservoirSize int
+
+	mu      sync.Mutex
+	now     func() time.Time
+	started bool
+	files   map[string]*file
+	rules   []*rule
+	lastAt  time.Time
+}
+
+var _ gmetric.Collector = (*Tailer)(nil)
+
+type file struct {
+	path        string
+	f           *os.File
+	info        os.FileInfo
+	offset      int64
+	fingerprint string
+	partial     []byte
+}
+
+type rule struct {
+	Rule
+	valueIndex int
+	metrics    []*gmetric.Metric
+
+	count  uint64
+	total  uint64
+	seen   uint64
+	sum    float64
+	min    float64
+	max    float64
+	values gmetric.Reservoir
+}
+
+type state struct {
+	Files map[string]fileState `json:"files"`
+}
+
+type fileState struct {
+	Offset      int64  `json:"offset"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Metrics returns the metrics provided by the rules.
+func (t *Tailer) Metrics() []*gmetric.Metric {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.init(); err != nil {
+		return nil
+	}
+	var metrics []*gmetric.Metric
+	for _, r := range t.rules {
+		metrics = append(metrics, r.metrics...)
+	}
+	return metrics
+}
+
+// Collect reads the new lines from the files and returns the samples for the
+// rules. The rates and distributions cover the lines read since the last
+// call to Collect.
+func (t *Tailer) Collect() ([]gmetric.Sample, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+
+	var errs gmetric.MultiError
+	if !t.started {
+		if err := t.restore(); err != nil {
+			errs = append(errs, err)
+		}
+		t.started = true
+		t.lastAt = now()
+	}
+
+	for _, path := range t.Paths {
+		if err := t.follow(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if t.StateFile != "" {
+		if err := t.save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	at := now()
+	elapsed := at.Sub(t.lastAt).Seconds()
+	t.lastAt = at
+	var samples []gmetric.Sample
+	for _, r := range t.rules {
+		samples = append(samples, r.samples(elapsed)...)
+	}
+
+	if len(errs) == 0 {
+		return samples, nil
+	}
+	return samples, errs
+}
+
+// Close the open files.
+func (t *Tailer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var errs gmetric.MultiError
+	for path, f := range t.files {
+		if err := f.f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(t.files, path)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (t *Tailer) init() error {
+	if t.rules != nil {
+		return nil
+	}
+	rules := make([]*rule, 0, len(t.Rules))
+	for _, r := range t.Rules {
+		if r.Pattern == nil {
+			return errNoPattern
+		}
+		rr := &rule{Rule: r, valueIndex: -1}
+		if r.Kind == Distribution {
+			rr.valueIndex = 1
+			if r.Value != "" {
+				rr.valueIndex = r.Pattern.SubexpIndex(r.Value)
+			}
+			if rr.valueIndex < 1 || rr.valueIndex > r.Pattern.NumSubexp() {
+				return fmt.Errorf("gmlogtail: rule %s has no submatch for the value", r.Name)
+			}
+		}
+		rr.metrics = t.metrics(r)
+		rules = append(rules, rr)
+	}
+	t.rules = rules
+	return nil
+}
+
+func (t *Tailer) metrics(r Rule) []*gmetric.Metric {
+	groups := r.Groups
+	if len(groups) == 0 {
+		groups = t.Groups
+	}
+	metric := func(name, title string) *gmetric.Metric {
+		return &gmetric.Metric{
+			Name:      name,
+			Title:     title,
+			Units:     r.Units,
+			Groups:    groups,
+			ValueType: gmetric.ValueFloat64,
+			Slope:     gmetric.SlopeBoth,
+		}
+	}
+	switch r.Kind {
+	case Counter:
+		m := metric(r.Name, r.Name)
+		m.Slope = gmetric.SlopePositive
+		return []*gmetric.Metric{m}
+	case Rate:
+		return []*gmetric.Metric{metric(r.Name, r.Name)}
+	}
+	metrics := []*gmetric.Metric{
+		metric(r.Name+"_avg", r.Name+" average"),
+		metric(r.Name+"_min", r.Name+" minimum"),
+		metric(r.Name+"_max", r.Name+" maximum"),
+	}
+	for _, p := range percentiles(r) {
+		suffix := gmetric.PercentileSuffix(p)
+		metrics = append(metrics, metric(r.Name+"_"+suffix, r.Name+" "+suffix))
+	}
+	return metrics
+}
+
+func percentiles(r Rule) []float64 {
+	if len(r.Percentiles) == 0 {
+		return defaultPercentiles
+	}
+	return r.Percentiles
+}
+
+// Opens the files at their saved offset, or at the end without one.
+func (t *Tailer) restore() error {
+	var saved state
+	if t.StateFile != "" {
+		b, err := ioutil.ReadFile(t.StateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &saved); err != nil {
+				return fmt.Errorf("gmlogtail: invalid state file %s: %s", t.StateFile, err)
+			}
+		}
+	}
+
+	var errs gmetric.MultiError
+	for _, path := range t.Paths {
+		f, err := open(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		offset := f.info.Size()
+		if s, ok := saved.Files[path]; ok {
+			offset = 0
+			if s.Offset <= f.info.Size() {
+				if fp, err := fingerprint(f.f, s.Offset); err == nil && fp == s.Fingerprint {
+					offset = s.Offset
+				}
+			}
+		}
+		if _, err := f.f.Seek(offset, io.SeekStart); err != nil {
+			f.f.Close()
+			errs = append(errs, err)
+			continue
+		}
+		f.offset = offset
+		if f.fingerprint, err = fingerprint(f.f, offset); err != nil {
+			f.f.Close()
+			errs = append(errs, err)
+			continue
+		}
+		t.setFile(f)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (t *Tailer) setFile(f *file) {
+	if t.files == nil {
+		t.files = make(map[string]*file)
+	}
+	t.files[f.path] = f
+}
+
+// Reads the new lines from the path, handling rotation and truncation.
+func (t *Tailer) follow(path string) error {
+	f := t.files[path]
+	info, statErr := os.Stat(path)
+
+	if f != nil {
+		if statErr == nil && !os.SameFile(f.info, info) {
+			// Rotated, finish the old file and start the new one.
+			err := t.read(f)
+			f.f.Close()
+			delete(t.files, path)
+			f = nil
+			if err != nil {
+				return err
+			}
+		} else if statErr == nil && f.truncated(info) {
+			// Truncated, start again from the beginning.
+			if _, err := f.f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			f.offset = 0
+			f.partial = nil
+		}
+	}
+
+	if f == nil {
+		if statErr != nil {
+			if os.IsNotExist(statErr) {
+				return nil
+			}
+			return statErr
+		}
+		var err error
+		if f, err = open(path); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		t.setFile(f)
+	}
+	if statErr == nil {
+		f.info = info
+	}
+	if err := t.read(f); err != nil {
+		return err
+	}
+	var err error
+	f.fingerprint, err = fingerprint(f.f, f.offset)
+	return err
+}
+
+// Reports if the file was truncated, even if it has since been written up to
+// or beyond the offset again.
+func (f *file) truncated(info os.FileInfo) bool {
+	if info.Size() < f.offset {
+		return true
+	}
+	fp, err := fingerprint(f.f, f.offset)
+	return err == nil && fp != f.fingerprint
+}
+
+func open(path string) (*file, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &file{path: path, f: f, info: info}, nil
+}
+
+// Reads the file until EOF and matches the complete lines.
+func (t *Tailer) read(f *file) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.f.Read(buf)
+		if n > 0 {
+			data := append(f.partial, buf[:n]...)
+			for {
+				i := bytes.IndexByte(data, '\n')
+				if i < 0 {
+					break
+				}
+				t.match(data[:i])
+				f.offset += int64(i + 1)
+				data = data[i+1:]
+			}
+			f.partial = append([]byte(nil), data...)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Tailer) match(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	for _, r := range t.rules {
+		if r.valueIndex < 0 {
+			if r.Pattern.Match(line) {
+				r.count++
+				r.total++
+			}
+			continue
+		}
+		m := r.Pattern.FindSubmatch(line)
+		if m == nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(string(m[r.valueIndex]), 64)
+		if err != nil {
+			continue
+		}
+		r.observe(v, t.ReservoirSize)
+	}
+}
+
+func (r *rule) observe(v float64, size int) {
+	if r.seen == 0 || v < r.min {
+		r.min = v
+	}
+	if r.seen == 0 || v > r.max {
+		r.max = v
+	}
+	r.seen++
+	r.sum += v
+	r.values.Size = size
+	r.values.Observe(v)
+}
+
+func (r *rule) samples(elapsed float64) []gmetric.Sample {
+	switch r.Kind {
+	case Counter:
+		r.count = 0
+		return []gmetric.Sample{{Metric: r.metrics[0], Value: float64(r.total)}}
+	case Rate:
+		count := r.count
+		r.count = 0
+		if elapsed <= 0 {
+			return nil
+		}
+		return []gmetric.Sample{{Metric: r.metrics[0], Value: float64(count) / elapsed}}
+	}
+
+	var avg float64
+	if r.seen > 0 {
+		avg = r.sum / float64(r.seen)
+	}
+	samples := []gmetric.Sample{
+		{Metric: r.metrics[0], Value: avg},
+		{Metric: r.metrics[1], Value: r.min},
+		{Metric: r.metrics[2], Value: r.max},
+	}
+	for i, p := range percentiles(r.Rule) {
+		samples = append(samples, gmetric.Sample{
+			Metric: r.metrics[3+i],
+			Value:  r.values.Percentile(p),
+		})
+	}
+	r.seen, r.sum, r.min, r.max = 0, 0, 0, 0
+	r.values.Reset()
+	return samples
+}
+
+func (t *Tailer) save() error {
+	s := state{Files: make(map[string]fileState)}
+	for path, f := range t.files {
+		s.Files[path] = fileState{Offset: f.offset, Fingerprint: f.fingerprint}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so a crash never leaves a partial state file.
+	tmp, err := ioutil.TempFile(filepath.Dir(t.StateFile), filepath.Base(t.StateFile))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), t.StateFile)
+}
+
+// Returns a hash of the start of the file, up to the offset, which is used to
+// recognize the file.
+func fingerprint(f *os.File, offset int64) (string, error) {
+	n := offset
+	if n > fingerprintSize {
+		n = fingerprintSize
+	}
+	b := make([]byte, n)
+	if _, err := f.ReadAt(b, 0); err != nil && err != io.EOF {
+		return "", err
+	}
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,225 @@
+package gmlogtail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type fixture struct {
+	t     *testing.T
+	dir   string
+	log   string
+	state string
+	at    time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	dir, err := ioutil.TempDir("", "gmlogtail_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{
+		t:     t,
+		dir:   dir,
+		log:   filepath.Join(dir, "access.log"),
+		state: filepath.Join(dir, "state.json"),
+		at:    time.Unix(1500000000, 0),
+	}
+}
+
+func (f *fixture) Close() {
+	os.RemoveAll(f.dir)
+}
+
+func (f *fixture) Append(path string, lines string) {
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if _, err := w.WriteString(lines); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) Tailer() *Tailer {
+	return &Tailer{
+		Paths:     []string{f.log},
+		StateFile: f.state,
+		Groups:    []string{"web"},
+		Rules: []Rule{
+			{
+				Name:    "requests",
+				Pattern: regexp.MustCompile(`GET|POST`),
+				Kind:    Counter,
+			},
+			{
+				Name:    "errors",
+				Pattern: regexp.MustCompile(` 5\d\d `),
+				Kind:    Rate,
+				Units:   "errors/sec",
+			},
+			{
+				Name:    "latency",
+				Pattern: regexp.MustCompile(`took=(?P<ms>[0-9.]+)ms`),
+				Kind:    Distribution,
+				Value:   "ms",
+				Units:   "ms",
+			},
+		},
+		now: func() time.Time { return f.at },
+	}
+}
+
+func (f *fixture) Collect(t *Tailer, elapsed time.Duration) map[string]interface{} {
+	f.at = f.at.Add(elapsed)
+	samples, err := t.Collect()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	for _, s := range samples {
+		values[s.Metric.Name] = s.Value
+	}
+	return values
+}
+
+func checkValue(t *testing.T, values map[string]interface{}, name string, expected interface{}) {
+	actual, ok := values[name]
+	if !ok {
+		t.Fatalf("missing value for %s in %v", name, values)
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("was expecting %v for %s but got %v", expected, name, actual)
+	}
+}
+
+func TestTailer(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t)
+	defer f.Close()
+
+	// Existing content is skipped when there is no saved state.
+	f.Append(f.log, "GET / 200 took=1ms\n")
+	tailer := f.Tailer()
+	defer tailer.Close()
+	v := f.Collect(tailer, 0)
+	checkValue(t, v, "requests", 0)
+
+	f.Append(f.log, "GET /a 200 took=10ms\nPOST /b 500 took=30ms\nGET /c 503 took=20ms\nGET /d 200 took=")
+	v = f.Collect(tailer, 2*time.Second)
+	checkValue(t, v, "requests", 3)
+	checkValue(t, v, "errors", 1)
+	checkValue(t, v, "latency_avg", 20)
+	checkValue(t, v, "latency_min", 10)
+	checkValue(t, v, "latency_max", 30)
+	checkValue(t, v, "latency_p50", 20)
+	checkValue(t, v, "latency_p99", 30)
+
+	// The partial line is completed.
+	f.Append(f.log, "40ms\n")
+	v = f.Collect(tailer, time.Second)
+	checkValue(t, v, "requests", 4)
+	checkValue(t, v, "errors", 0)
+	checkValue(t, v, "latency_avg", 40)
+
+	// Rotation, the remainder of the old file and the new file are read.
+	f.Append(f.log, "GET /e 200 took=1ms\n")
+	if err := os.Rename(f.log, f.log+".1"); err != nil {
+		t.Fatal(err)
+	}
+	f.Append(f.log, "GET /f 500 took=1ms\n")
+	v = f.Collect(tailer, time.Second)
+	checkValue(t, v, "requests", 6)
+	checkValue(t, v, "errors", 1)
+
+	// Truncation starts from the beginning again.
+	if err := os.Truncate(f.log, 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Append(f.log, "GET /g 200 took=1ms\n")
+	v = f.Collect(tailer, time.Second)
+	checkValue(t, v, "requests", 7)
+}
+
+func TestTailerRestart(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t)
+	defer f.Close()
+
+	f.Append(f.log, "")
+	first := f.Tailer()
+	f.Collect(first, 0)
+	f.Append(f.log, "GET /a 200 took=1ms\n")
+	checkValue(t, f.Collect(first, time.Second), "requests", 1)
+	first.Close()
+
+	// Lines written while stopped are read after the restart.
+	f.Append(f.log, "GET /b 200 took=1ms\nGET /c 200 took=1ms\n")
+	second := f.Tailer()
+	defer second.Close()
+	checkValue(t, f.Collect(second, time.Second), "requests", 2)
+}
+
+func TestTailerRestartAfterRotation(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t)
+	defer f.Close()
+
+	f.Append(f.log, "")
+	first := f.Tailer()
+	f.Collect(first, 0)
+	f.Append(f.log, "GET /a 200 took=1ms\nGET /b 200 took=1ms\n")
+	f.Collect(first, time.Second)
+	first.Close()
+
+	// The file was replaced while stopped, so it is read from the start.
+	if err := os.Remove(f.log); err != nil {
+		t.Fatal(err)
+	}
+	f.Append(f.log, "POST /x 200 took=1ms\nPOST /y 200 took=1ms\nPOST /z 200 took=1ms\n")
+	second := f.Tailer()
+	defer second.Close()
+	checkValue(t, f.Collect(second, time.Second), "requests", 3)
+}
+
+func TestTailerMetrics(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t)
+	defer f.Close()
+	metrics := f.Tailer().Metrics()
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.Name)
+	}
+	expected := "[requests errors latency_avg latency_min latency_max latency_p50 latency_p95 latency_p99]"
+	if fmt.Sprint(names) != expected {
+		t.Fatalf("was expecting %s but got %v", expected, names)
+	}
+	if metrics[0].Groups[0] != "web" || metrics[1].Units != "errors/sec" {
+		t.Fatalf("unexpected metrics %+v %+v", metrics[0], metrics[1])
+	}
+}
+
+func TestTailerInvalidRules(t *testing.T) {
+	t.Parallel()
+	tailer := &Tailer{Rules: []Rule{{Name: "nopattern"}}}
+	if _, err := tailer.Collect(); err != errNoPattern {
+		t.Fatalf("was expecting errNoPattern but got %v", err)
+	}
+	tailer = &Tailer{Rules: []Rule{{
+		Name:    "novalue",
+		Pattern: regexp.MustCompile("took"),
+		Kind:    Distribution,
+	}}}
+	if _, err := tailer.Collect(); err == nil {
+		t.Fatal("was expecting an error")
+	}
+}
@@ -24,42 +24,113 @@ type ExtraData struct {
 type Metric struct {
 	Name      string    `xml:"NAME,attr"`
 	Value     string    `xml:"VAL,attr"`
+	Type      string    `xml:"TYPE,attr"`
 	Unit      string    `xml:"UNITS,attr"`
 	Slope     string    `xml:"SLOPE,attr"`
 	Tn        int       `xml:"TN,attr"`
 	Tmax      int       `xml:"TMAX,attr"`
 	Dmax      int       `xml:"DMAX,attr"`
+	Source    string    `xml:"SOURCE,attr"`
 	ExtraData ExtraData `xml:"EXTRA_DATA"`
 }
 
 // Host as returned by gmon.
 type Host struct {
-	Name         string   `xml:"NAME,attr"`
-	IP           string   `xml:"IP,attr"`
-	Tags         string   `xml:"TAGS,attr"`
-	Reported     int      `xml:"REPORTED,attr"`
-	Tn           int      `xml:"TN,attr"`
-	Tmax         int      `xml:"TMAX,attr"`
-	Dmax         int      `xml:"DMAX,attr"`
-	Location     string   `xml:"LOCATION,attr"`
-	GmondStarted int      `xml:"GMOND_STARTED,attr"`
-	Metrics      []Metric `xml:"METRIC"`
+	Name         string    `xml:"NAME,attr"`
+	IP           string    `xml:"IP,attr"`
+	Tags         string    `xml:"TAGS,attr"`
+	Reported     int       `xml:"REPORTED,attr"`
+	Tn           int       `xml:"TN,attr"`
+	Tmax         int       `xml:"TMAX,attr"`
+	Dmax         int       `xml:"DMAX,attr"`
+	Location     string    `xml:"LOCATION,attr"`
+	GmondStarted int       `xml:"GMOND_STARTED,attr"`
+	Metrics      []Metric  `xml:"METRIC"`
+	ExtraData    ExtraData `xml:"EXTRA_DATA"`
 }
 
-// Cluster as returned by gmon.
+// Cluster as returned by gmon. The Summary and SummaryMetrics are only
+// present in the summary output of gmetad, in place of the Hosts.
 type Cluster struct {
-	Name      string `xml:"NAME,attr"`
-	Owner     string `xml:"OWNER,attr"`
-	LatLong   string `xml:"LATLONG,attr"`
-	URL       string `xml:"URL,attr"`
-	Localtime int    `xml:"LOCALTIME,attr"`
-	Hosts     []Host `xml:"HOST"`
+	Name           string          `xml:"NAME,attr"`
+	Owner          string          `xml:"OWNER,attr"`
+	LatLong        string          `xml:"LATLONG,attr"`
+	URL            string          `xml:"URL,attr"`
+	Localtime      int             `xml:"LOCALTIME,attr"`
+	Hosts          []Host          `xml:"HOST"`
+	Summary        *HostsSummary   `xml:"HOSTS"`
+	SummaryMetrics []SummaryMetric `xml:"METRICS"`
 }
 
-// Ganglia is the root document returned by gmon.
+// HostsSummary is the number of hosts up and down in a cluster or grid, as
+// returned by gmetad.
+type HostsSummary struct {
+	Up     int    `xml:"UP,attr"`
+	Down   int    `xml:"DOWN,attr"`
+	Source string `xml:"SOURCE,attr"`
+}
+
+// SummaryMetric is a metric summed over the hosts of a cluster or grid, as
+// returned by gmetad.
+type SummaryMetric struct {
+	Name      string    `xml:"NAME,attr"`
+	Sum       float64   `xml:"SUM,attr"`
+	Num       int       `xml:"NUM,attr"`
+	Type      string    `xml:"TYPE,attr"`
+	Unit      string    `xml:"UNITS,attr"`
+	Slope     string    `xml:"SLOPE,attr"`
+	Source    string    `xml:"SOURCE,attr"`
+	ExtraData ExtraData `xml:"EXTRA_DATA"`
+}
+
+// Grid as returned by gmetad. A grid contains the clusters it polls and the
+// grids of the gmetads it polls, which are only summarized.
+type Grid struct {
+	Name           string          `xml:"NAME,attr"`
+	Authority      string          `xml:"AUTHORITY,attr"`
+	Localtime      int             `xml:"LOCALTIME,attr"`
+	Clusters       []Cluster       `xml:"CLUSTER"`
+	Grids          []Grid          `xml:"GRID"`
+	Summary        *HostsSummary   `xml:"HOSTS"`
+	SummaryMetrics []SummaryMetric `xml:"METRICS"`
+}
+
+// Ganglia is the root document returned by gmond or gmetad. The output of
+// gmond has Clusters, while the output of gmetad has Grids. The Source is
+// either "gmond" or "gmetad".
 type Ganglia struct {
 	XMLNAME  xml.Name  `xml:"GANGLIA_XML"`
+	Version  string    `xml:"VERSION,attr"`
+	Source   string    `xml:"SOURCE,attr"`
 	Clusters []Cluster `xml:"CLUSTER"`
+	Grids    []Grid    `xml:"GRID"`
+	Hosts    []Host    `xml:"HOST"`
+}
+
+// AllClusters returns the clusters of the document, including those within
+// grids at any depth. The top level clusters come first, followed by the
+// clusters of each grid in turn, where the clusters of a grid come before
+// those of its nested grids. The order of clusters interleaved with grids in
+// the document is not preserved.
+func (g *Ganglia) AllClusters() []*Cluster {
+	var clusters []*Cluster
+	for i := range g.Clusters {
+		clusters = append(clusters, &g.Clusters[i])
+	}
+	for i := range g.Grids {
+		clusters = g.Grids[i].appendClusters(clusters)
+	}
+	return clusters
+}
+
+func (g *Grid) appendClusters(clusters []*Cluster) []*Cluster {
+	for i := range g.Clusters {
+		clusters = append(clusters, &g.Clusters[i])
+	}
+	for i := range g.Grids {
+		clusters = g.Grids[i].appendClusters(clusters)
+	}
+	return clusters
 }
 
 // Read the gmond XML output.
@@ -0,0 +1,97 @@
+package gmon
+
+import (
+	"strings"
+	"testing"
+)
+
+const gmetadSummary = `<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?>
+<GANGLIA_XML VERSION="3.6.0" SOURCE="gmetad">
+<GRID NAME="top" AUTHORITY="http://top/ganglia/" LOCALTIME="1500000000">
+<CLUSTER NAME="web" LOCALTIME="1500000000" OWNER="ops" LATLONG="" URL="">
+<HOSTS UP="2" DOWN="1" SOURCE="gmetad"/>
+<METRICS NAME="load_one" SUM="2.50" NUM="2" TYPE="float" UNITS=" " SLOPE="both" SOURCE="gmond">
+<EXTRA_DATA><EXTRA_ELEMENT NAME="GROUP" VAL="load"/></EXTRA_DATA>
+</METRICS>
+</CLUSTER>
+<GRID NAME="east" AUTHORITY="http://east/ganglia/" LOCALTIME="1500000001">
+<HOSTS UP="10" DOWN="0" SOURCE="gmetad"/>
+<METRICS NAME="cpu_num" SUM="40" NUM="10" TYPE="uint16" UNITS="CPUs" SLOPE="zero" SOURCE="gmond"/>
+<CLUSTER NAME="db" LOCALTIME="1500000001" OWNER="dba">
+<HOST NAME="db1" IP="10.0.1.1" REPORTED="1500000000" TN="1" TMAX="20" DMAX="0"/>
+</CLUSTER>
+</GRID>
+</GRID>
+</GANGLIA_XML>
+`
+
+func TestReadGrid(t *testing.T) {
+	t.Parallel()
+	g, err := Read(strings.NewReader(gmetadSummary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Clusters) != 0 || len(g.Grids) != 1 {
+		t.Fatalf("was expecting a single grid but got %+v", g)
+	}
+	top := g.Grids[0]
+	if top.Name != "top" || top.Authority != "http://top/ganglia/" || top.Localtime != 1500000000 {
+		t.Fatalf("unexpected grid %+v", top)
+	}
+
+	web := top.Clusters[0]
+	if web.Summary == nil || web.Summary.Up != 2 || web.Summary.Down != 1 {
+		t.Fatalf("unexpected hosts summary %+v", web.Summary)
+	}
+	load := web.SummaryMetrics[0]
+	if load.Name != "load_one" || load.Sum != 2.5 || load.Num != 2 || load.Source != "gmond" {
+		t.Fatalf("unexpected summary metric %+v", load)
+	}
+	if e := load.ExtraData.ExtraElements; len(e) != 1 || e[0].Val != "load" {
+		t.Fatalf("unexpected extras %+v", e)
+	}
+
+	east := top.Grids[0]
+	if east.Summary.Up != 10 || east.SummaryMetrics[0].Sum != 40 || east.Authority != "http://east/ganglia/" {
+		t.Fatalf("unexpected nested grid %+v", east)
+	}
+
+	var names []string
+	for _, c := range g.AllClusters() {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "web,db" {
+		t.Fatalf("was expecting the clusters web and db but got %v", names)
+	}
+}
+
+const gmondOutput = `<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?>
+<GANGLIA_XML VERSION="3.6.0" SOURCE="gmond">
+<CLUSTER NAME="web" LOCALTIME="1500000000" OWNER="ops" LATLONG="" URL="">
+<HOST NAME="web1" IP="10.0.0.1" REPORTED="1500000000" TN="5" TMAX="20" DMAX="0">
+<METRIC NAME="load_one" VAL="0.5" TYPE="float" UNITS="" TN="5" TMAX="70" DMAX="0" SLOPE="both"/>
+<METRIC NAME="cpu_num" VAL="4" TYPE="uint16" UNITS="CPUs" TN="5" TMAX="1200" DMAX="0" SLOPE="zero"/>
+</HOST>
+</CLUSTER>
+</GANGLIA_XML>
+`
+
+func TestReadGmond(t *testing.T) {
+	t.Parallel()
+	g, err := Read(strings.NewReader(gmondOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Grids) != 0 || len(g.Clusters) != 1 {
+		t.Fatalf("was expecting a single cluster but got %+v", g)
+	}
+	if clusters := g.AllClusters(); len(clusters) != 1 || clusters[0] != &g.Clusters[0] {
+		t.Fatal("was expecting AllClusters to refer to the top level cluster")
+	}
+	if g.Clusters[0].Summary != nil {
+		t.Fatal("was not expecting a hosts summary from gmond")
+	}
+	if n := len(g.Clusters[0].Hosts[0].Metrics); n != 2 {
+		t.Fatalf("was expecting 2 metrics but got %d", n)
+	}
+}
@@ -0,0 +1,182 @@
+package gmon
+
+import "sort"
+
+// Index provides constant time lookups over a snapshot, and iteration in
+// sorted order. The Index refers to the clusters, hosts and metrics of the
+// snapshot, which must not be modified while the Index is in use. Hosts
+// directly within the document, as opposed to within a cluster, are indexed
+// under the cluster named "". If names are repeated, the first one is
+// indexed, with clusters taken in the order of AllClusters.
+type Index struct {
+	clusters     map[string]*clusterEntry
+	clusterNames []string
+	byIP         map[string]*hostEntry
+}
+
+type clusterEntry struct {
+	cluster     *Cluster
+	hosts       map[string]*hostEntry
+	hostNames   []string
+	metricNames []string
+}
+
+type hostEntry struct {
+	cluster     *Cluster
+	host        *Host
+	metrics     map[string]*Metric
+	metricNames []string
+}
+
+// NewIndex indexes the snapshot, including the clusters within grids.
+func NewIndex(g *Ganglia) *Index {
+	i := &Index{
+		clusters: make(map[string]*clusterEntry),
+		byIP:     make(map[string]*hostEntry),
+	}
+	if len(g.Hosts) > 0 {
+		i.add(&Cluster{Hosts: g.Hosts})
+	}
+	for _, c := range g.AllClusters() {
+		i.add(c)
+	}
+	sort.Strings(i.clusterNames)
+	return i
+}
+
+func (i *Index) add(c *Cluster) {
+	if _, ok := i.clusters[c.Name]; ok {
+		return
+	}
+	ce := &clusterEntry{cluster: c, hosts: make(map[string]*hostEntry)}
+	i.clusters[c.Name] = ce
+	i.clusterNames = append(i.clusterNames, c.Name)
+
+	metricNames := make(map[string]bool)
+	for j := range c.Hosts {
+		h := &c.Hosts[j]
+		if _, ok := ce.hosts[h.Name]; ok {
+			continue
+		}
+		he := &hostEntry{cluster: c, host: h, metrics: make(map[string]*Metric)}
+		for k := range h.Metrics {
+			m := &h.Metrics[k]
+			if _, ok := he.metrics[m.Name]; ok {
+				continue
+			}
+			he.metrics[m.Name] = m
+			he.metricNames = append(he.metricNames, m.Name)
+			metricNames[m.Name] = true
+		}
+		sort.Strings(he.metricNames)
+		ce.hosts[h.Name] = he
+		ce.hostNames = append(ce.hostNames, h.Name)
+		if _, ok := i.byIP[h.IP]; !ok && h.IP != "" {
+			i.byIP[h.IP] = he
+		}
+	}
+	sort.Strings(ce.hostNames)
+	for name := range metricNames {
+		ce.metricNames = append(ce.metricNames, name)
+	}
+	sort.Strings(ce.metricNames)
+}
+
+// Cluster returns the named cluster, or nil.
+func (i *Index) Cluster(name string) *Cluster {
+	if ce, ok := i.clusters[name]; ok {
+		return ce.cluster
+	}
+	return nil
+}
+
+// Host returns the named host in the named cluster, or nil.
+func (i *Index) Host(cluster, host string) *Host {
+	if he := i.host(cluster, host); he != nil {
+		return he.host
+	}
+	return nil
+}
+
+// Metric returns the named metric of the host in the cluster, or nil.
+func (i *Index) Metric(cluster, host, metric string) *Metric {
+	if he := i.host(cluster, host); he != nil {
+		return he.metrics[metric]
+	}
+	return nil
+}
+
+// HostByIP returns the host with the IP along with its cluster, or nils.
+func (i *Index) HostByIP(ip string) (*Cluster, *Host) {
+	if he, ok := i.byIP[ip]; ok {
+		return he.cluster, he.host
+	}
+	return nil, nil
+}
+
+// Clusters returns the clusters sorted by name.
+func (i *Index) Clusters() []*Cluster {
+	clusters := make([]*Cluster, 0, len(i.clusterNames))
+	for _, name := range i.clusterNames {
+		clusters = append(clusters, i.clusters[name].cluster)
+	}
+	return clusters
+}
+
+// Hosts returns the hosts of the cluster sorted by name.
+func (i *Index) Hosts(cluster string) []*Host {
+	ce, ok := i.clusters[cluster]
+	if !ok {
+		return nil
+	}
+	hosts := make([]*Host, 0, len(ce.hostNames))
+	for _, name := range ce.hostNames {
+		hosts = append(hosts, ce.hosts[name].host)
+	}
+	return hosts
+}
+
+// Metrics returns the metrics of the host in the cluster sorted by name.
+func (i *Index) Metrics(cluster, host string) []*Metric {
+	he := i.host(cluster, host)
+	if he == nil {
+		return nil
+	}
+	metrics := make([]*Metric, 0, len(he.metricNames))
+	for _, name := range he.metricNames {
+		metrics = append(metrics, he.metrics[name])
+	}
+	return metrics
+}
+
+// MetricNames returns the names of the metrics reported by any host in the
+// cluster, sorted.
+func (i *Index) MetricNames(cluster string) []string {
+	if ce, ok := i.clusters[cluster]; ok {
+		return append([]string(nil), ce.metricNames...)
+	}
+	return nil
+}
+
+// HostsWithMetric returns the hosts in the cluster reporting the named
+// metric, sorted by name.
+func (i *Index) HostsWithMetric(cluster, metric string) []*Host {
+	ce, ok := i.clusters[cluster]
+	if !ok {
+		return nil
+	}
+	var hosts []*Host
+	for _, name := range ce.hostNames {
+		if he := ce.hosts[name]; he.metrics[metric] != nil {
+			hosts = append(hosts, he.host)
+		}
+	}
+	return hosts
+}
+
+func (i *Index) host(cluster, host string) *hostEntry {
+	if ce, ok := i.clusters[cluster]; ok {
+		return ce.hosts[host]
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package gmon
+
+import (
+	"strings"
+	"testing"
+)
+
+func hostNames(hosts []*Host) string {
+	var names []string
+	for _, h := range hosts {
+		names = append(names, h.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+	g, err := Read(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := NewIndex(g)
+
+	var clusters []string
+	for _, c := range i.Clusters() {
+		clusters = append(clusters, c.Name)
+	}
+	if strings.Join(clusters, ",") != "db,web" {
+		t.Fatalf("was expecting the sorted clusters but got %v", clusters)
+	}
+	if c := i.Cluster("web"); c == nil || c.Owner != "ops" {
+		t.Fatalf("unexpected cluster %+v", c)
+	}
+	if i.Cluster("missing") != nil || i.Host("web", "missing") != nil || i.Metric("web", "web1", "missing") != nil {
+		t.Fatal("was expecting nil for missing entries")
+	}
+
+	m := i.Metric("web", "web2", "load_one")
+	if m == nil || m.Value != "1.5" {
+		t.Fatalf("unexpected metric %+v", m)
+	}
+	if m != &g.Grids[0].Clusters[0].Hosts[1].Metrics[0] {
+		t.Fatal("was expecting the index to refer to the snapshot")
+	}
+
+	c, h := i.HostByIP("10.0.1.1")
+	if c == nil || c.Name != "db" || h.Name != "db1" {
+		t.Fatalf("unexpected host by IP %+v %+v", c, h)
+	}
+	if c, h := i.HostByIP("10.9.9.9"); c != nil || h != nil {
+		t.Fatal("was expecting nils for an unknown IP")
+	}
+
+	if names := hostNames(i.Hosts("web")); names != "web1,web2" {
+		t.Fatalf("unexpected hosts %s", names)
+	}
+	var metrics []string
+	for _, m := range i.Metrics("web", "web1") {
+		metrics = append(metrics, m.Name)
+	}
+	if strings.Join(metrics, ",") != "cpu_num,load_one" {
+		t.Fatalf("was expecting the sorted metrics but got %v", metrics)
+	}
+	if names := i.MetricNames("web"); strings.Join(names, ",") != "cpu_num,load_one" {
+		t.Fatalf("unexpected metric names %v", names)
+	}
+	if names := hostNames(i.HostsWithMetric("web", "cpu_num")); names != "web1" {
+		t.Fatalf("unexpected hosts with cpu_num %s", names)
+	}
+	if names := hostNames(i.HostsWithMetric("web", "load_one")); names != "web1,web2" {
+		t.Fatalf("unexpected hosts with load_one %s", names)
+	}
+}
+
+func TestIndexRootHosts(t *testing.T) {
+	t.Parallel()
+	i := NewIndex(&Ganglia{Hosts: []Host{{Name: "a", IP: "10.0.0.1"}}})
+	if h := i.Host("", "a"); h == nil {
+		t.Fatal("was expecting the root host under the empty cluster name")
+	}
+}
@@ -0,0 +1,123 @@
+package gmon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// FilterSummary asks gmetad for the summary of the clusters and grids in the
+// queried path instead of their hosts.
+const FilterSummary = "summary"
+
+var errEmptyPathElement = errors.New("gmon: empty query path element")
+
+// The default timeout for a query.
+const defaultQueryTimeout = 30 * time.Second
+
+// QueryClient queries the interactive port of gmetad, which returns only the
+// part of the grid in the requested path, such as a single cluster or host.
+type QueryClient struct {
+	// The network and address of the interactive port, such as "tcp" and
+	// "localhost:8652".
+	Network string
+	Addr    string
+
+	// Bounds the time to connect and read the response. Defaults to 30
+	// seconds.
+	Timeout time.Duration
+}
+
+// Query the given path, such as "cluster", "host" for a host in a cluster.
+// An empty path queries the whole grid. The optional filter, such as
+// FilterSummary, is passed to gmetad.
+func (q *QueryClient) Query(filter string, path ...string) (*Ganglia, error) {
+	request, err := queryRequest(filter, path)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := q.Timeout
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	c, err := net.DialTimeout(q.Network, q.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.Write([]byte(request)); err != nil {
+		return nil, err
+	}
+	return Read(bufio.NewReader(c))
+}
+
+// Cluster queries a single cluster, optionally summarized.
+func (q *QueryClient) Cluster(name string, summary bool) (*Cluster, error) {
+	filter := ""
+	if summary {
+		filter = FilterSummary
+	}
+	g, err := q.Query(filter, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range g.AllClusters() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("gmon: cluster %s not found", name)
+}
+
+// Host queries a single host in a cluster.
+func (q *QueryClient) Host(cluster, host string) (*Host, error) {
+	g, err := q.Query("", cluster, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range g.AllClusters() {
+		if c.Name != cluster {
+			continue
+		}
+		for i := range c.Hosts {
+			if c.Hosts[i].Name == host {
+				return &c.Hosts[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("gmon: host %s not found in cluster %s", host, cluster)
+}
+
+// Builds the request line, such as "/cluster/host?filter=summary".
+func queryRequest(filter string, path []string) (string, error) {
+	var buf strings.Builder
+	for _, p := range path {
+		if p == "" {
+			return "", errEmptyPathElement
+		}
+		if strings.ContainsAny(p, "/?\r\n") {
+			return "", fmt.Errorf("gmon: invalid query path element %q", p)
+		}
+		buf.WriteByte('/')
+		buf.WriteString(p)
+	}
+	if len(path) == 0 {
+		buf.WriteByte('/')
+	}
+	if filter != "" {
+		if strings.ContainsAny(filter, "&\r\n") {
+			return "", fmt.Errorf("gmon: invalid query filter %q", filter)
+		}
+		buf.WriteString("?filter=")
+		buf.WriteString(filter)
+	}
+	buf.WriteByte('\n')
+	return buf.String(), nil
+}
@@ -0,0 +1,98 @@
+package gmon
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a fake gmetad interactive port, responding with the document for
+// the request line.
+func fakeGmetad(t *testing.T, responses map[string]string) (*QueryClient, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan string, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(c).ReadString('\n')
+			line = strings.TrimSpace(line)
+			requests <- line
+			c.Write([]byte(responses[line]))
+			c.Close()
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return &QueryClient{Network: "tcp", Addr: l.Addr().String(), Timeout: 5 * time.Second}, requests
+}
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+	q, requests := fakeGmetad(t, map[string]string{
+		"/":                   gmetadSummary,
+		"/web?filter=summary": gmetadSummary,
+		"/db/db1":             gmetadSummary,
+		"/missing/host":       gmetadSummary,
+	})
+
+	g, err := q.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Grids) != 1 || <-requests != "/" {
+		t.Fatalf("unexpected response %+v", g)
+	}
+
+	c, err := q.Cluster("web", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r != "/web?filter=summary" {
+		t.Fatalf("unexpected request %q", r)
+	}
+	if c.Summary == nil || c.Summary.Up != 2 {
+		t.Fatalf("unexpected cluster %+v", c)
+	}
+
+	h, err := q.Host("db", "db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r != "/db/db1" {
+		t.Fatalf("unexpected request %q", r)
+	}
+	if h.IP != "10.0.1.1" {
+		t.Fatalf("unexpected host %+v", h)
+	}
+
+	if _, err := q.Host("missing", "host"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("was expecting a not found error but got %v", err)
+	}
+}
+
+func TestQueryRequest(t *testing.T) {
+	t.Parallel()
+	if _, err := queryRequest("", []string{"a/b"}); err == nil {
+		t.Fatal("was expecting an error for a path element with a slash")
+	}
+	if _, err := queryRequest("", []string{""}); err != errEmptyPathElement {
+		t.Fatalf("was expecting errEmptyPathElement but got %v", err)
+	}
+	if _, err := queryRequest("a&b", nil); err == nil {
+		t.Fatal("was expecting an error for an invalid filter")
+	}
+	r, err := queryRequest(FilterSummary, []string{"web", "web1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "/web/web1?filter=summary\n" {
+		t.Fatalf("unexpected request %q", r)
+	}
+}
@@ -0,0 +1,398 @@
+// Package selector implements a small query language over gmon data.
+//
+// A selector is a list of terms separated by spaces, all of which must match.
+// A term is a field, an operator and a value, for example:
+//
+//	cluster=web-* host=~^fe[0-9]+ metric=load_* value>4 sort=-value limit=10
+//
+// The fields are cluster, host, ip, metric, type, units, group and value. The
+// group field matches any GROUP extra of the metric. The operators are "="
+// and "!=" for glob patterns, "=~" and "!~" for regular expressions, and
+// ">", ">=", "<" and "<=" for numeric comparisons, which only match metrics
+// with a numeric value. Values containing spaces may be double quoted.
+//
+// The sort option orders the rows by cluster, host, metric or value,
+// descending when prefixed with "-", and the limit option keeps only the
+// first rows, so "sort=-value limit=5" selects the top 5. Without a sort the
+// rows are ordered by cluster, host and metric.
+package selector
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/facebookgo/ganglia/gmon"
+)
+
+// Row is a single metric matched by a Selector.
+type Row struct {
+	Cluster *gmon.Cluster
+	Host    *gmon.Host
+	Metric  *gmon.Metric
+
+	// The numeric value of the metric, if Numeric.
+	Value   float64
+	Numeric bool
+}
+
+// Selector is a parsed selector expression.
+type Selector struct {
+	terms []*term
+	sort  string
+	desc  bool
+	limit int
+}
+
+type operator int
+
+const (
+	opGlob operator = iota
+	opNotGlob
+	opRegexp
+	opNotRegexp
+	opGreater
+	opGreaterEqual
+	opLess
+	opLessEqual
+)
+
+// The operators, longest first so a prefix does not match first.
+var operators = []struct {
+	token string
+	op    operator
+}{
+	{"=~", opRegexp},
+	{"!~", opNotRegexp},
+	{"!=", opNotGlob},
+	{">=", opGreaterEqual},
+	{"<=", opLessEqual},
+	{"=", opGlob},
+	{">", opGreater},
+	{"<", opLess},
+}
+
+var fields = map[string]bool{
+	"cluster": true,
+	"host":    true,
+	"ip":      true,
+	"metric":  true,
+	"type":    true,
+	"units":   true,
+	"group":   true,
+	"value":   true,
+}
+
+var sortKeys = map[string]bool{
+	"cluster": true,
+	"host":    true,
+	"metric":  true,
+	"value":   true,
+}
+
+type term struct {
+	field  string
+	op     operator
+	value  string
+	re     *regexp.Regexp
+	number float64
+}
+
+// Parse a selector expression.
+func Parse(expr string) (*Selector, error) {
+	words, err := split(expr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Selector{}
+	for _, word := range words {
+		t, err := parseTerm(word)
+		if err != nil {
+			return nil, err
+		}
+		switch t.field {
+		case "sort":
+			if t.op != opGlob {
+				return nil, fmt.Errorf("selector: invalid sort %q", word)
+			}
+			s.sort, s.desc = strings.TrimPrefix(t.value, "-"), strings.HasPrefix(t.value, "-")
+			if !sortKeys[s.sort] {
+				return nil, fmt.Errorf("selector: unknown sort key %q", s.sort)
+			}
+		case "limit":
+			n, err := strconv.Atoi(t.value)
+			if t.op != opGlob || err != nil || n < 0 {
+				return nil, fmt.Errorf("selector: invalid limit %q", word)
+			}
+			s.limit = n
+		default:
+			s.terms = append(s.terms, t)
+		}
+	}
+	return s, nil
+}
+
+// MustParse is like Parse but panics if the expression is invalid.
+func MustParse(expr string) *Selector {
+	s, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Splits the expression on spaces, keeping double quoted values together.
+func split(expr string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for i := 0; i < len(expr); i++ {
+		b := expr[i]
+		switch {
+		case quoted && b == '\\' && i+1 < len(expr):
+			i++
+			word.WriteByte(expr[i])
+		case b == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (b == ' ' || b == '\t' || b == '\n'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(b)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("selector: unterminated quote in %q", expr)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func parseTerm(word string) (*term, error) {
+	i := strings.IndexAny(word, "=!<>")
+	if i <= 0 {
+		return nil, fmt.Errorf("selector: invalid term %q", word)
+	}
+	t := &term{field: word[:i]}
+	rest := word[i:]
+	found := false
+	for _, o := range operators {
+		if strings.HasPrefix(rest, o.token) {
+			t.op, t.value, found = o.op, rest[len(o.token):], true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("selector: invalid operator in %q", word)
+	}
+	if t.field == "sort" || t.field == "limit" {
+		return t, nil
+	}
+	if !fields[t.field] {
+		return nil, fmt.Errorf("selector: unknown field %q", t.field)
+	}
+
+	switch t.op {
+	case opGlob, opNotGlob:
+		if _, err := path.Match(t.value, ""); err != nil {
+			return nil, fmt.Errorf("selector: invalid pattern in %q: %s", word, err)
+		}
+	case opRegexp, opNotRegexp:
+		re, err := regexp.Compile(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("selector: invalid regexp in %q: %s", word, err)
+		}
+		t.re = re
+	default:
+		if t.field != "value" {
+			return nil, fmt.Errorf("selector: numeric comparison on %s in %q", t.field, word)
+		}
+		n, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("selector: invalid number in %q", word)
+		}
+		t.number = n
+	}
+	return t, nil
+}
+
+// Select returns the rows matching the Selector.
+func (s *Selector) Select(g *gmon.Ganglia) []Row {
+	var rows []Row
+	clusters := g.AllClusters()
+	if len(g.Hosts) > 0 {
+		clusters = append([]*gmon.Cluster{{Hosts: g.Hosts}}, clusters...)
+	}
+	for _, c := range clusters {
+		if !s.matchAll(func(t *term) (bool, bool) { return t.matchCluster(c) }) {
+			continue
+		}
+		for i := range c.Hosts {
+			h := &c.Hosts[i]
+			if !s.matchAll(func(t *term) (bool, bool) { return t.matchHost(h) }) {
+				continue
+			}
+			for j := range h.Metrics {
+				m := &h.Metrics[j]
+				row := Row{Cluster: c, Host: h, Metric: m}
+				if m.IsNumeric() {
+					if v, err := m.Float64(); err == nil {
+						row.Value, row.Numeric = v, true
+					}
+				}
+				if s.matchAll(func(t *term) (bool, bool) { return t.matchMetric(&row) }) {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+
+	sort.Stable(&sorter{rows: rows, key: s.sort, desc: s.desc})
+	if s.limit > 0 && len(rows) > s.limit {
+		rows = rows[:s.limit]
+	}
+	return rows
+}
+
+// Reports if every term applicable at a level matches. The function returns
+// whether the term matched and whether it applies at the level.
+func (s *Selector) matchAll(match func(*term) (bool, bool)) bool {
+	for _, t := range s.terms {
+		if ok, applies := match(t); applies && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *term) matchCluster(c *gmon.Cluster) (bool, bool) {
+	if t.field != "cluster" {
+		return false, false
+	}
+	return t.matchString(c.Name), true
+}
+
+func (t *term) matchHost(h *gmon.Host) (bool, bool) {
+	switch t.field {
+	case "host":
+		return t.matchString(h.Name), true
+	case "ip":
+		return t.matchString(h.IP), true
+	}
+	return false, false
+}
+
+func (t *term) matchMetric(r *Row) (bool, bool) {
+	switch t.field {
+	case "metric":
+		return t.matchString(r.Metric.Name), true
+	case "type":
+		return t.matchString(r.Metric.Type), true
+	case "units":
+		return t.matchString(r.Metric.Unit), true
+	case "group":
+		var groups []string
+		for _, e := range r.Metric.ExtraData.ExtraElements {
+			if e.Name == "GROUP" {
+				groups = append(groups, e.Val)
+			}
+		}
+		return t.matchAny(groups), true
+	case "value":
+		switch t.op {
+		case opGreater:
+			return r.Numeric && r.Value > t.number, true
+		case opGreaterEqual:
+			return r.Numeric && r.Value >= t.number, true
+		case opLess:
+			return r.Numeric && r.Value < t.number, true
+		case opLessEqual:
+			return r.Numeric && r.Value <= t.number, true
+		}
+		return t.matchString(r.Metric.Value), true
+	}
+	return false, false
+}
+
+func (t *term) matchString(s string) bool {
+	return t.matchAny([]string{s})
+}
+
+// Reports if any of the strings matches, or for negated operators if none
+// does.
+func (t *term) matchAny(values []string) bool {
+	negated := t.op == opNotGlob || t.op == opNotRegexp
+	for _, v := range values {
+		var matched bool
+		if t.re != nil {
+			matched = t.re.MatchString(v)
+		} else {
+			matched, _ = path.Match(t.value, v)
+		}
+		if matched {
+			return !negated
+		}
+	}
+	return negated
+}
+
+type sorter struct {
+	rows []Row
+	key  string
+	desc bool
+}
+
+func (s *sorter) Len() int      { return len(s.rows) }
+func (s *sorter) Swap(i, j int) { s.rows[i], s.rows[j] = s.rows[j], s.rows[i] }
+
+func (s *sorter) Less(i, j int) bool {
+	a, b := &s.rows[i], &s.rows[j]
+	if s.key == "value" && a.Numeric != b.Numeric {
+		// Non-numeric values are always last.
+		return a.Numeric
+	}
+	c := s.compare(a, b)
+	if s.desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+func (s *sorter) compare(a, b *Row) int {
+	switch s.key {
+	case "value":
+		switch {
+		case a.Numeric && a.Value < b.Value:
+			return -1
+		case a.Numeric && a.Value > b.Value:
+			return 1
+		case !a.Numeric:
+			return strings.Compare(a.Metric.Value, b.Metric.Value)
+		}
+		return 0
+	case "host":
+		return strings.Compare(a.Host.Name, b.Host.Name)
+	case "metric":
+		return strings.Compare(a.Metric.Name, b.Metric.Name)
+	case "cluster":
+		return strings.Compare(a.Cluster.Name, b.Cluster.Name)
+	}
+	// The default order is by cluster, host and metric.
+	if c := strings.Compare(a.Cluster.Name, b.Cluster.Name); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Host.Name, b.Host.Name); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Metric.Name, b.Metric.Name)
+}
@@ -0,0 +1,148 @@
+package selector_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ganglia/gmon"
+	"github.com/facebookgo/ganglia/gmon/selector"
+)
+
+func host(name, ip string, metrics ...gmon.Metric) gmon.Host {
+	return gmon.Host{Name: name, IP: ip, Metrics: metrics}
+}
+
+func metric(name, typ, value string, groups ...string) gmon.Metric {
+	m := gmon.Metric{Name: name, Type: typ, Value: value}
+	for _, g := range groups {
+		m.ExtraData.ExtraElements = append(
+			m.ExtraData.ExtraElements, gmon.ExtraElement{Name: "GROUP", Val: g})
+	}
+	return m
+}
+
+var snapshot = &gmon.Ganglia{
+	Grids: []gmon.Grid{{
+		Name: "grid",
+		Clusters: []gmon.Cluster{
+			{Name: "web-east", Hosts: []gmon.Host{
+				host("fe1", "10.0.0.1",
+					metric("load_one", "float", "5.5", "load"),
+					metric("load_five", "float", "3", "load"),
+					metric("os_name", "string", "Linux", "system")),
+				host("fe2", "10.0.0.2",
+					metric("load_one", "float", "0.5", "load"),
+					metric("os_name", "string", "Linux", "system")),
+				host("admin", "10.0.0.3",
+					metric("load_one", "float", "9", "load")),
+			}},
+			{Name: "db", Hosts: []gmon.Host{
+				host("db1", "10.0.1.1",
+					metric("load_one", "float", "7", "load"),
+					metric("disk free", "double", "100", "disk", "system")),
+			}},
+		},
+	}},
+}
+
+func selectRows(t *testing.T, expr string) string {
+	s, err := selector.Parse(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for _, r := range s.Select(snapshot) {
+		rows = append(rows, r.Cluster.Name+"/"+r.Host.Name+"/"+r.Metric.Name+"="+r.Metric.Value)
+	}
+	return strings.Join(rows, " ")
+}
+
+func TestSelect(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		expr     string
+		expected string
+	}{
+		{
+			`cluster=web-* host=~^fe[0-9]+ metric=load_* value>4`,
+			"web-east/fe1/load_one=5.5",
+		},
+		{
+			`metric=load_one value>=5.5 sort=-value`,
+			"web-east/admin/load_one=9 db/db1/load_one=7 web-east/fe1/load_one=5.5",
+		},
+		{
+			`metric=load_one sort=-value limit=2`,
+			"web-east/admin/load_one=9 db/db1/load_one=7",
+		},
+		{
+			`metric=load_one value<1`,
+			"web-east/fe2/load_one=0.5",
+		},
+		{
+			`group=system value>0`,
+			"db/db1/disk free=100",
+		},
+		{
+			`group=system metric!=os_*`,
+			"db/db1/disk free=100",
+		},
+		{
+			`metric="disk free"`,
+			"db/db1/disk free=100",
+		},
+		{
+			`ip=10.0.1.* metric!~^load`,
+			"db/db1/disk free=100",
+		},
+		{
+			`host=fe2 type=string value=Lin*`,
+			"web-east/fe2/os_name=Linux",
+		},
+		{
+			`host=fe1 sort=metric`,
+			"web-east/fe1/load_five=3 web-east/fe1/load_one=5.5 web-east/fe1/os_name=Linux",
+		},
+		{
+			`cluster=db`,
+			"db/db1/disk free=100 db/db1/load_one=7",
+		},
+	}
+	for _, c := range cases {
+		if actual := selectRows(t, c.expr); actual != c.expected {
+			t.Fatalf("was expecting %q for %s but got %q", c.expected, c.expr, actual)
+		}
+	}
+}
+
+func TestSelectValues(t *testing.T) {
+	t.Parallel()
+	rows := selector.MustParse("host=fe1 sort=value").Select(snapshot)
+	if len(rows) != 3 || !rows[0].Numeric || rows[0].Value != 3 || rows[2].Numeric {
+		t.Fatalf("was expecting numeric values first but got %+v", rows)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{`bogus=1`, "unknown field"},
+		{`host`, "invalid term"},
+		{`host=~(`, "invalid regexp"},
+		{`host=[`, "invalid pattern"},
+		{`host>1`, "numeric comparison on host"},
+		{`value>abc`, "invalid number"},
+		{`sort=ip`, "unknown sort key"},
+		{`limit=-1`, "invalid limit"},
+		{`metric="a`, "unterminated quote"},
+	}
+	for _, c := range cases {
+		_, err := selector.Parse(c.expr)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("was expecting error %q for %s but got %v", c.err, c.expr, err)
+		}
+	}
+}
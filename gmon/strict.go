@@ -0,0 +1,140 @@
+package gmon
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// The elements of the gmond 3.x DTD, with their attributes and children.
+var schema = map[string]struct {
+	attrs    []string
+	children []string
+}{
+	"GANGLIA_XML": {
+		attrs:    []string{"VERSION", "SOURCE"},
+		children: []string{"GRID", "CLUSTER", "HOST"},
+	},
+	"GRID": {
+		attrs:    []string{"NAME", "AUTHORITY", "LOCALTIME"},
+		children: []string{"CLUSTER", "GRID", "HOSTS", "METRICS"},
+	},
+	"CLUSTER": {
+		attrs:    []string{"NAME", "OWNER", "LATLONG", "URL", "LOCALTIME"},
+		children: []string{"HOST", "HOSTS", "METRICS"},
+	},
+	"HOST": {
+		attrs: []string{
+			"NAME", "IP", "TAGS", "REPORTED", "TN", "TMAX", "DMAX", "LOCATION",
+			"GMOND_STARTED",
+		},
+		children: []string{"METRIC", "EXTRA_DATA"},
+	},
+	"METRIC": {
+		attrs: []string{
+			"NAME", "VAL", "TYPE", "UNITS", "TN", "TMAX", "DMAX", "SLOPE", "SOURCE",
+		},
+		children: []string{"EXTRA_DATA"},
+	},
+	"HOSTS": {
+		attrs: []string{"UP", "DOWN", "SOURCE"},
+	},
+	"METRICS": {
+		attrs:    []string{"NAME", "SUM", "NUM", "TYPE", "UNITS", "SLOPE", "SOURCE"},
+		children: []string{"EXTRA_DATA"},
+	},
+	"EXTRA_DATA": {
+		children: []string{"EXTRA_ELEMENT"},
+	},
+	"EXTRA_ELEMENT": {
+		attrs: []string{"NAME", "VAL"},
+	},
+}
+
+// The enumerated attribute values of the DTD.
+var enums = map[string][]string{
+	"TYPE": {
+		"string", "int8", "uint8", "int16", "uint16", "int32", "uint32", "float",
+		"double", "timestamp",
+	},
+	"SLOPE": {"zero", "positive", "negative", "both", "unspecified"},
+}
+
+// ReadStrict reads the gmond or gmetad XML output like Read, but fails on
+// elements, attributes or enumerated values that are not in the gmond 3.x
+// DTD.
+func ReadStrict(r io.Reader) (*Ganglia, error) {
+	raw := xml.NewDecoder(r)
+	raw.CharsetReader = charsetReader
+	ganglia := Ganglia{}
+	decoder := xml.NewTokenDecoder(&validator{decoder: raw})
+	if err := decoder.Decode(&ganglia); err != nil {
+		return nil, err
+	}
+	return &ganglia, nil
+}
+
+// validator is a xml.TokenReader that validates the elements read against
+// the schema.
+type validator struct {
+	decoder *xml.Decoder
+	stack   []string
+}
+
+func (v *validator) Token() (xml.Token, error) {
+	t, err := v.decoder.Token()
+	if err != nil {
+		return t, err
+	}
+	switch t := t.(type) {
+	case xml.StartElement:
+		if err := v.check(t); err != nil {
+			return nil, err
+		}
+		v.stack = append(v.stack, t.Name.Local)
+	case xml.EndElement:
+		if len(v.stack) > 0 {
+			v.stack = v.stack[:len(v.stack)-1]
+		}
+	}
+	return t, nil
+}
+
+func (v *validator) check(se xml.StartElement) error {
+	name := se.Name.Local
+	element, ok := schema[name]
+	if !ok {
+		return v.errorf("unknown element %s", name)
+	}
+	if len(v.stack) == 0 {
+		if name != "GANGLIA_XML" {
+			return v.errorf("unexpected root element %s", name)
+		}
+	} else if parent := v.stack[len(v.stack)-1]; !contains(schema[parent].children, name) {
+		return v.errorf("unexpected element %s in %s", name, parent)
+	}
+
+	for _, a := range se.Attr {
+		if !contains(element.attrs, a.Name.Local) {
+			return v.errorf("unknown attribute %s on %s", a.Name.Local, name)
+		}
+		if values, ok := enums[a.Name.Local]; ok && !contains(values, a.Value) {
+			return v.errorf("invalid %s %q on %s", a.Name.Local, a.Value, name)
+		}
+	}
+	return nil
+}
+
+func (v *validator) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(
+		"gmon: %s at offset %d", fmt.Sprintf(format, args...), v.decoder.InputOffset())
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package gmon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadStrict(t *testing.T) {
+	t.Parallel()
+	for _, doc := range []string{gmondOutput, gmetadSummary, document} {
+		if _, err := ReadStrict(strings.NewReader(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := ReadStrict(strings.NewReader(`<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?>
+<!DOCTYPE GANGLIA_XML [
+   <!ELEMENT GANGLIA_XML (GRID|CLUSTER|HOST)*>
+]>
+<GANGLIA_XML VERSION="3.6.0" SOURCE="gmond">
+<HOST NAME="a" IP="10.0.0.1">
+<METRIC NAME="m" VAL="1" TYPE="uint32" SOURCE="gmond"/>
+<EXTRA_DATA><EXTRA_ELEMENT NAME="k" VAL="v"/></EXTRA_DATA>
+</HOST>
+</GANGLIA_XML>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Version != "3.6.0" || g.Source != "gmond" {
+		t.Fatalf("unexpected version %q and source %q", g.Version, g.Source)
+	}
+	h := g.Hosts[0]
+	if h.Metrics[0].Type != "uint32" || h.Metrics[0].Source != "gmond" {
+		t.Fatalf("unexpected metric %+v", h.Metrics[0])
+	}
+	if e := h.ExtraData.ExtraElements; len(e) != 1 || e[0].Name != "k" {
+		t.Fatalf("unexpected host extras %+v", e)
+	}
+}
+
+func TestReadStrictErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		doc string
+		err string
+	}{
+		{`<GANGLIA_XML FOO="1"/>`, "unknown attribute FOO on GANGLIA_XML"},
+		{`<CLUSTER/>`, "unexpected root element CLUSTER"},
+		{`<GANGLIA_XML><BOGUS/></GANGLIA_XML>`, "unknown element BOGUS"},
+		{`<GANGLIA_XML><METRIC/></GANGLIA_XML>`, "unexpected element METRIC in GANGLIA_XML"},
+		{`<GANGLIA_XML><HOST><METRIC TYPE="int64"/></HOST></GANGLIA_XML>`, `invalid TYPE "int64" on METRIC`},
+		{`<GANGLIA_XML><HOST><METRIC SLOPE="up"/></HOST></GANGLIA_XML>`, `invalid SLOPE "up" on METRIC`},
+	}
+	for _, c := range cases {
+		_, err := ReadStrict(strings.NewReader(c.doc))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("was expecting error %q for %s but got %v", c.err, c.doc, err)
+		}
+	}
+
+	// The lenient Read ignores what it does not know.
+	if _, err := Read(strings.NewReader(cases[0].doc)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+package gmon
+
+import "time"
+
+// gmetad considers a host down once it has not been heard from in this many
+// TMAX intervals.
+const downTmaxFactor = 4
+
+func seconds(s int) time.Duration {
+	return time.Duration(s) * time.Second
+}
+
+// Time returns the local time of the cluster when the document was produced.
+func (c *Cluster) Time() time.Time {
+	return time.Unix(int64(c.Localtime), 0)
+}
+
+// HostCounts returns the number of hosts up and down, from the hosts of the
+// cluster or, if it has none, from its summary.
+func (c *Cluster) HostCounts() (up, down int) {
+	if len(c.Hosts) == 0 && c.Summary != nil {
+		return c.Summary.Up, c.Summary.Down
+	}
+	for i := range c.Hosts {
+		if c.Hosts[i].IsDown() {
+			down++
+		} else {
+			up++
+		}
+	}
+	return up, down
+}
+
+// Time returns the local time of the grid when the document was produced.
+func (g *Grid) Time() time.Time {
+	return time.Unix(int64(g.Localtime), 0)
+}
+
+// ReportedTime returns the time the host last reported.
+func (h *Host) ReportedTime() time.Time {
+	return time.Unix(int64(h.Reported), 0)
+}
+
+// StartedTime returns the time gmond was started on the host, or the zero
+// Time if unknown.
+func (h *Host) StartedTime() time.Time {
+	if h.GmondStarted == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(h.GmondStarted), 0)
+}
+
+// SinceReport returns how long ago the host last reported, also known as TN.
+func (h *Host) SinceReport() time.Duration {
+	return seconds(h.Tn)
+}
+
+// TmaxDuration returns the maximum expected interval between reports.
+func (h *Host) TmaxDuration() time.Duration {
+	return seconds(h.Tmax)
+}
+
+// DmaxDuration returns the time after which a silent host is removed, or
+// zero if it is never removed.
+func (h *Host) DmaxDuration() time.Duration {
+	return seconds(h.Dmax)
+}
+
+// IsStale reports if the host has missed its expected report.
+func (h *Host) IsStale() bool {
+	return h.Tn > h.Tmax
+}
+
+// IsDown reports if the host is down, which gmetad defines as not having
+// reported for 4 times its TMAX.
+func (h *Host) IsDown() bool {
+	return h.Tn > downTmaxFactor*h.Tmax
+}
+
+// ExpiresIn returns how long until the host is removed unless it reports, and
+// false if it is never removed. The duration is negative if it is overdue.
+func (h *Host) ExpiresIn() (time.Duration, bool) {
+	return expiresIn(h.Tn, h.Dmax)
+}
+
+// SinceUpdate returns how long ago the metric was last updated, also known as
+// TN.
+func (m *Metric) SinceUpdate() time.Duration {
+	return seconds(m.Tn)
+}
+
+// UpdatedTime returns the time the metric was last updated, given the local
+// time of its cluster.
+func (m *Metric) UpdatedTime(localtime time.Time) time.Time {
+	return localtime.Add(-m.SinceUpdate())
+}
+
+// TmaxDuration returns the maximum expected interval between updates.
+func (m *Metric) TmaxDuration() time.Duration {
+	return seconds(m.Tmax)
+}
+
+// DmaxDuration returns the time after which a metric that is not updated is
+// removed, or zero if it is never removed.
+func (m *Metric) DmaxDuration() time.Duration {
+	return seconds(m.Dmax)
+}
+
+// IsStale reports if the metric has missed its expected update.
+func (m *Metric) IsStale() bool {
+	return m.Tn > m.Tmax
+}
+
+// IsDown reports if the metric has not been updated for 4 times its TMAX,
+// the same rule gmetad applies to hosts.
+func (m *Metric) IsDown() bool {
+	return m.Tn > downTmaxFactor*m.Tmax
+}
+
+// ExpiresIn returns how long until the metric is removed unless it is
+// updated, and false if it is never removed. The duration is negative if it
+// is overdue.
+func (m *Metric) ExpiresIn() (time.Duration, bool) {
+	return expiresIn(m.Tn, m.Dmax)
+}
+
+func expiresIn(tn, dmax int) (time.Duration, bool) {
+	if dmax == 0 {
+		return 0, false
+	}
+	return seconds(dmax - tn), true
+}
@@ -0,0 +1,83 @@
+package gmon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostLiveness(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		tn          int
+		stale, down bool
+	}{
+		{tn: 10},
+		{tn: 21, stale: true},
+		{tn: 80, stale: true},
+		{tn: 81, stale: true, down: true},
+	}
+	for _, c := range cases {
+		h := &Host{Tn: c.tn, Tmax: 20}
+		if h.IsStale() != c.stale || h.IsDown() != c.down {
+			t.Fatalf("was expecting stale %v and down %v for TN %d", c.stale, c.down, c.tn)
+		}
+	}
+}
+
+func TestHostTimes(t *testing.T) {
+	t.Parallel()
+	h := &Host{Reported: 1500000000, Tn: 30, Tmax: 20, Dmax: 100, GmondStarted: 1400000000}
+	if !h.ReportedTime().Equal(time.Unix(1500000000, 0)) {
+		t.Fatalf("unexpected reported time %s", h.ReportedTime())
+	}
+	if !h.StartedTime().Equal(time.Unix(1400000000, 0)) {
+		t.Fatalf("unexpected started time %s", h.StartedTime())
+	}
+	if h.SinceReport() != 30*time.Second || h.TmaxDuration() != 20*time.Second || h.DmaxDuration() != 100*time.Second {
+		t.Fatal("unexpected durations")
+	}
+	if d, ok := h.ExpiresIn(); !ok || d != 70*time.Second {
+		t.Fatalf("was expecting expiry in 70s but got %s %v", d, ok)
+	}
+	if _, ok := (&Host{Tn: 30}).ExpiresIn(); ok {
+		t.Fatal("was not expecting a host without DMAX to expire")
+	}
+	if !(&Host{}).StartedTime().IsZero() {
+		t.Fatal("was expecting a zero started time")
+	}
+}
+
+func TestMetricTimes(t *testing.T) {
+	t.Parallel()
+	m := &Metric{Tn: 90, Tmax: 60, Dmax: 60}
+	if !m.IsStale() || m.IsDown() {
+		t.Fatal("was expecting a stale metric that is not down")
+	}
+	local := time.Unix(1500000000, 0)
+	if !m.UpdatedTime(local).Equal(local.Add(-90 * time.Second)) {
+		t.Fatalf("unexpected updated time %s", m.UpdatedTime(local))
+	}
+	if d, ok := m.ExpiresIn(); !ok || d != -30*time.Second {
+		t.Fatalf("was expecting an overdue expiry but got %s %v", d, ok)
+	}
+}
+
+func TestClusterHostCounts(t *testing.T) {
+	t.Parallel()
+	c := &Cluster{Localtime: 1500000000, Hosts: []Host{
+		{Tn: 10, Tmax: 20},
+		{Tn: 100, Tmax: 20},
+		{Tn: 30, Tmax: 20},
+	}}
+	if up, down := c.HostCounts(); up != 2 || down != 1 {
+		t.Fatalf("was expecting 2 up and 1 down but got %d and %d", up, down)
+	}
+	if !c.Time().Equal(time.Unix(1500000000, 0)) {
+		t.Fatalf("unexpected cluster time %s", c.Time())
+	}
+
+	summary := &Cluster{Summary: &HostsSummary{Up: 5, Down: 2}}
+	if up, down := summary.HostCounts(); up != 5 || down != 2 {
+		t.Fatalf("was expecting the summary counts but got %d and %d", up, down)
+	}
+}
@@ -0,0 +1,173 @@
+package gmon
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ValueError describes a metric value that cannot be converted as requested.
+type ValueError struct {
+	Metric string
+	Type   string
+	Value  string
+	Reason string
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("gmon: metric %s of type %q with value %q %s", e.Metric, e.Type, e.Value, e.Reason)
+}
+
+// The kinds of values, derived from the metric TYPE.
+const (
+	kindUnknown = iota
+	kindString
+	kindInt
+	kindUint
+	kindFloat
+)
+
+// Returns the kind and size in bits of the declared type.
+func valueKind(typ string) (int, int) {
+	switch typ {
+	case "string":
+		return kindString, 0
+	case "int8":
+		return kindInt, 8
+	case "int16":
+		return kindInt, 16
+	case "int32":
+		return kindInt, 32
+	case "uint8":
+		return kindUint, 8
+	case "uint16":
+		return kindUint, 16
+	case "uint32", "timestamp":
+		return kindUint, 32
+	case "float":
+		return kindFloat, 32
+	case "double":
+		return kindFloat, 64
+	}
+	return kindUnknown, 0
+}
+
+// IsNumeric reports if the metric has a numeric value. Metrics without a
+// known TYPE are numeric if their value parses as a number.
+func (m *Metric) IsNumeric() bool {
+	switch kind, _ := valueKind(m.Type); kind {
+	case kindString:
+		return false
+	case kindUnknown:
+		_, err := strconv.ParseFloat(strings.TrimSpace(m.Value), 64)
+		return err == nil
+	}
+	return true
+}
+
+// Float64 returns the value of a numeric metric.
+func (m *Metric) Float64() (float64, error) {
+	kind, bits := valueKind(m.Type)
+	value := strings.TrimSpace(m.Value)
+	switch kind {
+	case kindString:
+		return 0, m.valueError("is not numeric")
+	case kindInt:
+		i, err := strconv.ParseInt(value, 10, bits)
+		if err != nil {
+			return 0, m.valueError("is not a valid integer")
+		}
+		return float64(i), nil
+	case kindUint:
+		u, err := strconv.ParseUint(value, 10, bits)
+		if err != nil {
+			return 0, m.valueError("is not a valid unsigned integer")
+		}
+		return float64(u), nil
+	}
+	// Floats are parsed as doubles even for the float type, the text already
+	// is the float value as formatted by gmond and parsing it to the nearest
+	// float32 would add rounding errors.
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, m.valueError("is not a valid number")
+	}
+	return f, nil
+}
+
+// Int64 returns the value of an integer metric, or of a floating point
+// metric with an integral value.
+func (m *Metric) Int64() (int64, error) {
+	kind, bits := valueKind(m.Type)
+	switch kind {
+	case kindInt:
+		i, err := strconv.ParseInt(strings.TrimSpace(m.Value), 10, bits)
+		if err != nil {
+			return 0, m.valueError("is not a valid integer")
+		}
+		return i, nil
+	case kindUint:
+		u, err := strconv.ParseUint(strings.TrimSpace(m.Value), 10, bits)
+		if err != nil {
+			return 0, m.valueError("is not a valid unsigned integer")
+		}
+		return int64(u), nil
+	}
+	f, err := m.Float64()
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, m.valueError("is not an integer")
+	}
+	return int64(f), nil
+}
+
+// Uint64 returns the value of a non-negative integer metric, or of a floating
+// point metric with a non-negative integral value.
+func (m *Metric) Uint64() (uint64, error) {
+	kind, bits := valueKind(m.Type)
+	if kind == kindUint {
+		u, err := strconv.ParseUint(strings.TrimSpace(m.Value), 10, bits)
+		if err != nil {
+			return 0, m.valueError("is not a valid unsigned integer")
+		}
+		return u, nil
+	}
+	i, err := m.Int64()
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, m.valueError("is negative")
+	}
+	return uint64(i), nil
+}
+
+func (m *Metric) valueError(reason string) error {
+	return &ValueError{Metric: m.Name, Type: m.Type, Value: m.Value, Reason: reason}
+}
+
+// NumericValues returns the values of the numeric metrics of the host by
+// name. Metrics with a malformed value are left out, and the error for the
+// first one is returned along with the other values.
+func (h *Host) NumericValues() (map[string]float64, error) {
+	values := make(map[string]float64, len(h.Metrics))
+	var first error
+	for i := range h.Metrics {
+		m := &h.Metrics[i]
+		if !m.IsNumeric() {
+			continue
+		}
+		f, err := m.Float64()
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		values[m.Name] = f
+	}
+	return values, first
+}
@@ -0,0 +1,91 @@
+package gmon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetricValues(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		typ, value string
+		numeric    bool
+		f          float64
+		i          int64
+		iErr       string
+		u          uint64
+		uErr       string
+	}{
+		{typ: "uint32", value: "4000000000", numeric: true, f: 4e9, i: 4e9, u: 4e9},
+		{typ: "int8", value: "-5", numeric: true, f: -5, i: -5, uErr: "is negative"},
+		{typ: "double", value: " 2.5 ", numeric: true, f: 2.5, iErr: "is not an integer", uErr: "is not an integer"},
+		{typ: "float", value: "3", numeric: true, f: 3, i: 3, u: 3},
+		{typ: "float", value: "0.17", numeric: true, f: 0.17, iErr: "is not an integer", uErr: "is not an integer"},
+		{typ: "timestamp", value: "1500000000", numeric: true, f: 1.5e9, i: 1.5e9, u: 1.5e9},
+		{typ: "", value: "7", numeric: true, f: 7, i: 7, u: 7},
+	}
+	for _, c := range cases {
+		m := &Metric{Name: "m", Type: c.typ, Value: c.value}
+		if m.IsNumeric() != c.numeric {
+			t.Fatalf("was expecting numeric %v for %+v", c.numeric, c)
+		}
+		f, err := m.Float64()
+		if err != nil || f != c.f {
+			t.Fatalf("was expecting %v for %+v but got %v %v", c.f, c, f, err)
+		}
+		i, err := m.Int64()
+		if c.iErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.iErr) {
+				t.Fatalf("was expecting error %q for %+v but got %v", c.iErr, c, err)
+			}
+		} else if err != nil || i != c.i {
+			t.Fatalf("was expecting %v for %+v but got %v %v", c.i, c, i, err)
+		}
+		u, err := m.Uint64()
+		if c.uErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.uErr) {
+				t.Fatalf("was expecting error %q for %+v but got %v", c.uErr, c, err)
+			}
+		} else if err != nil || u != c.u {
+			t.Fatalf("was expecting %v for %+v but got %v %v", c.u, c, u, err)
+		}
+	}
+}
+
+func TestMetricValueErrors(t *testing.T) {
+	t.Parallel()
+	cases := []*Metric{
+		{Name: "os_name", Type: "string", Value: "Linux"},
+		{Name: "big", Type: "uint8", Value: "300"},
+		{Name: "neg", Type: "uint16", Value: "-1"},
+		{Name: "bad", Type: "double", Value: "abc"},
+		{Name: "unknown", Value: "abc"},
+	}
+	for _, m := range cases {
+		_, err := m.Float64()
+		verr, ok := err.(*ValueError)
+		if !ok || verr.Metric != m.Name {
+			t.Fatalf("was expecting a ValueError for %s but got %v", m.Name, err)
+		}
+	}
+	if (&Metric{Type: "string", Value: "1"}).IsNumeric() {
+		t.Fatal("was not expecting a string metric to be numeric")
+	}
+}
+
+func TestNumericValues(t *testing.T) {
+	t.Parallel()
+	h := &Host{Metrics: []Metric{
+		{Name: "load_one", Type: "float", Value: "0.5"},
+		{Name: "os_name", Type: "string", Value: "Linux"},
+		{Name: "cpu_num", Type: "uint16", Value: "x"},
+		{Name: "mem_total", Type: "double", Value: "1024"},
+	}}
+	values, err := h.NumericValues()
+	if err == nil || !strings.Contains(err.Error(), "cpu_num") {
+		t.Fatalf("was expecting an error for cpu_num but got %v", err)
+	}
+	if len(values) != 2 || values["load_one"] != 0.5 || values["mem_total"] != 1024 {
+		t.Fatalf("unexpected values %v", values)
+	}
+}
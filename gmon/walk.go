@@ -0,0 +1,188 @@
+package gmon
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+var (
+	// SkipChildren is returned by the Cluster or Host function of a Visitor to
+	// skip the hosts or metrics within it. Returned by the Metric function it
+	// skips the remaining metrics of the host.
+	SkipChildren = errors.New("gmon: skip children")
+
+	// SkipAll is returned by any function of a Visitor to stop the walk. Walk
+	// then returns nil.
+	SkipAll = errors.New("gmon: skip all")
+)
+
+// Visitor receives the clusters, hosts and metrics of a document as they are
+// decoded. Any function may be nil. The Cluster and Host passed to the
+// functions do not have their Hosts, Metrics, summaries or ExtraData
+// populated, and only the current ones are held in memory. They must not be
+// retained after the function returns.
+type Visitor struct {
+	Cluster func(c *Cluster) error
+	Host    func(c *Cluster, h *Host) error
+	Metric  func(c *Cluster, h *Host, m *Metric) error
+}
+
+// Walk decodes the gmond or gmetad XML output token by token, calling the
+// Visitor for every cluster, host and metric. Memory use is bounded by the
+// largest single element rather than the document. An error returned by the
+// Visitor, other than SkipChildren and SkipAll, stops the walk and is
+// returned.
+func Walk(r io.Reader, v Visitor) error {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charsetReader
+	err := walk(decoder, v)
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// RemoteWalk will connect to the given network/address and walk the output.
+func RemoteWalk(network, addr string, v Visitor) error {
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return Walk(bufio.NewReader(c), v)
+}
+
+func walk(d *xml.Decoder, v Visitor) error {
+	var cluster *Cluster
+	var host *Host
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "CLUSTER":
+				cluster = clusterFromStart(t)
+				skip := v.Host == nil && v.Metric == nil
+				if v.Cluster != nil {
+					if err := v.Cluster(cluster); err == SkipChildren {
+						skip = true
+					} else if err != nil {
+						return err
+					}
+				}
+				if skip {
+					if err := d.Skip(); err != nil {
+						return err
+					}
+					cluster = nil
+				}
+			case "HOST":
+				host = hostFromStart(t)
+				skip := v.Metric == nil
+				if v.Host != nil {
+					if err := v.Host(cluster, host); err == SkipChildren {
+						skip = true
+					} else if err != nil {
+						return err
+					}
+				}
+				if skip {
+					if err := d.Skip(); err != nil {
+						return err
+					}
+					host = nil
+				}
+			case "METRIC":
+				if host == nil || v.Metric == nil {
+					if err := d.Skip(); err != nil {
+						return err
+					}
+					continue
+				}
+				var m Metric
+				if err := d.DecodeElement(&m, &t); err != nil {
+					return err
+				}
+				if err := v.Metric(cluster, host, &m); err == SkipChildren {
+					// Skips the remaining metrics of the host.
+					if err := d.Skip(); err != nil {
+						return err
+					}
+					host = nil
+				} else if err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "CLUSTER":
+				cluster = nil
+			case "HOST":
+				host = nil
+			}
+		}
+	}
+}
+
+func clusterFromStart(se xml.StartElement) *Cluster {
+	c := &Cluster{}
+	for _, a := range se.Attr {
+		switch a.Name.Local {
+		case "NAME":
+			c.Name = a.Value
+		case "OWNER":
+			c.Owner = a.Value
+		case "LATLONG":
+			c.LatLong = a.Value
+		case "URL":
+			c.URL = a.Value
+		case "LOCALTIME":
+			c.Localtime = atoi(a.Value)
+		}
+	}
+	return c
+}
+
+func hostFromStart(se xml.StartElement) *Host {
+	h := &Host{}
+	for _, a := range se.Attr {
+		switch a.Name.Local {
+		case "NAME":
+			h.Name = a.Value
+		case "IP":
+			h.IP = a.Value
+		case "TAGS":
+			h.Tags = a.Value
+		case "REPORTED":
+			h.Reported = atoi(a.Value)
+		case "TN":
+			h.Tn = atoi(a.Value)
+		case "TMAX":
+			h.Tmax = atoi(a.Value)
+		case "DMAX":
+			h.Dmax = atoi(a.Value)
+		case "LOCATION":
+			h.Location = a.Value
+		case "GMOND_STARTED":
+			h.GmondStarted = atoi(a.Value)
+		}
+	}
+	return h
+}
+
+// Parses an integer attribute. Malformed values are treated as zero.
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
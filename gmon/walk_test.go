@@ -0,0 +1,178 @@
+package gmon
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const document = `<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?>
+<GANGLIA_XML VERSION="3.6.0" SOURCE="gmetad">
+<GRID NAME="grid" AUTHORITY="http://example/" LOCALTIME="1500000000">
+<CLUSTER NAME="web" LOCALTIME="1500000000" OWNER="ops" LATLONG="" URL="">
+<HOST NAME="web1" IP="10.0.0.1" REPORTED="1500000000" TN="5" TMAX="20" DMAX="0" LOCATION="" GMOND_STARTED="1400000000" TAGS="">
+<METRIC NAME="load_one" VAL="0.5" TYPE="float" UNITS="" TN="5" TMAX="70" DMAX="0" SLOPE="both">
+<EXTRA_DATA><EXTRA_ELEMENT NAME="GROUP" VAL="load"/></EXTRA_DATA>
+</METRIC>
+<METRIC NAME="cpu_num" VAL="4" TYPE="uint16" UNITS="CPUs" TN="5" TMAX="1200" DMAX="0" SLOPE="zero"/>
+</HOST>
+<HOST NAME="web2" IP="10.0.0.2" REPORTED="1500000000" TN="7" TMAX="20" DMAX="0">
+<METRIC NAME="load_one" VAL="1.5" TYPE="float" UNITS="" TN="7" TMAX="70" DMAX="0" SLOPE="both"/>
+</HOST>
+</CLUSTER>
+<CLUSTER NAME="db" LOCALTIME="1500000000" OWNER="dba">
+<HOST NAME="db1" IP="10.0.1.1" REPORTED="1500000000" TN="1" TMAX="20" DMAX="0">
+<METRIC NAME="load_one" VAL="2.5" TYPE="float" UNITS="" TN="1" TMAX="70" DMAX="0" SLOPE="both"/>
+</HOST>
+</CLUSTER>
+</GRID>
+</GANGLIA_XML>
+`
+
+// Records the visited elements as "cluster/host/metric=value" paths.
+func record(v *Visitor) *[]string {
+	var visited []string
+	if v.Cluster == nil {
+		v.Cluster = func(c *Cluster) error { return nil }
+	}
+	cluster, host, metric := v.Cluster, v.Host, v.Metric
+	v.Cluster = func(c *Cluster) error {
+		visited = append(visited, c.Name)
+		return cluster(c)
+	}
+	if host != nil {
+		v.Host = func(c *Cluster, h *Host) error {
+			visited = append(visited, c.Name+"/"+h.Name)
+			return host(c, h)
+		}
+	}
+	if metric != nil {
+		v.Metric = func(c *Cluster, h *Host, m *Metric) error {
+			visited = append(visited, c.Name+"/"+h.Name+"/"+m.Name+"="+m.Value)
+			return metric(c, h, m)
+		}
+	}
+	return &visited
+}
+
+func checkVisited(t *testing.T, actual *[]string, expected ...string) {
+	if !reflect.DeepEqual(*actual, expected) {
+		t.Fatalf("was expecting %q but got %q", expected, *actual)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+	var hosts []Host
+	v := Visitor{
+		Host: func(c *Cluster, h *Host) error {
+			hosts = append(hosts, *h)
+			return nil
+		},
+		Metric: func(c *Cluster, h *Host, m *Metric) error { return nil },
+	}
+	visited := record(&v)
+	if err := Walk(strings.NewReader(document), v); err != nil {
+		t.Fatal(err)
+	}
+	checkVisited(t, visited,
+		"web", "web/web1", "web/web1/load_one=0.5", "web/web1/cpu_num=4",
+		"web/web2", "web/web2/load_one=1.5",
+		"db", "db/db1", "db/db1/load_one=2.5",
+	)
+	if h := hosts[0]; h.IP != "10.0.0.1" || h.Tn != 5 || h.GmondStarted != 1400000000 {
+		t.Fatalf("unexpected host %+v", h)
+	}
+}
+
+func TestWalkMetricExtras(t *testing.T) {
+	t.Parallel()
+	var extras []ExtraElement
+	err := Walk(strings.NewReader(document), Visitor{
+		Metric: func(c *Cluster, h *Host, m *Metric) error {
+			extras = append(extras, m.ExtraData.ExtraElements...)
+			return SkipAll
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ExtraElement{{Name: "GROUP", Val: "load"}}
+	if !reflect.DeepEqual(extras, expected) {
+		t.Fatalf("was expecting %v but got %v", expected, extras)
+	}
+}
+
+func TestWalkSkipChildren(t *testing.T) {
+	t.Parallel()
+	v := Visitor{
+		Cluster: func(c *Cluster) error {
+			if c.Name == "web" {
+				return SkipChildren
+			}
+			return nil
+		},
+		Host: func(c *Cluster, h *Host) error { return nil },
+		Metric: func(c *Cluster, h *Host, m *Metric) error {
+			return SkipChildren
+		},
+	}
+	visited := record(&v)
+	if err := Walk(strings.NewReader(document), v); err != nil {
+		t.Fatal(err)
+	}
+	checkVisited(t, visited, "web", "db", "db/db1", "db/db1/load_one=2.5")
+}
+
+func TestWalkSkipMetrics(t *testing.T) {
+	t.Parallel()
+	v := Visitor{
+		Host: func(c *Cluster, h *Host) error { return nil },
+		Metric: func(c *Cluster, h *Host, m *Metric) error {
+			return SkipChildren
+		},
+	}
+	visited := record(&v)
+	if err := Walk(strings.NewReader(document), v); err != nil {
+		t.Fatal(err)
+	}
+	checkVisited(t, visited,
+		"web", "web/web1", "web/web1/load_one=0.5",
+		"web/web2", "web/web2/load_one=1.5",
+		"db", "db/db1", "db/db1/load_one=2.5",
+	)
+}
+
+func TestWalkStop(t *testing.T) {
+	t.Parallel()
+	v := Visitor{
+		Host: func(c *Cluster, h *Host) error {
+			if h.Name == "web2" {
+				return SkipAll
+			}
+			return nil
+		},
+	}
+	visited := record(&v)
+	if err := Walk(strings.NewReader(document), v); err != nil {
+		t.Fatal(err)
+	}
+	checkVisited(t, visited, "web", "web/web1", "web/web2")
+
+	boom := errors.New("boom")
+	err := Walk(strings.NewReader(document), Visitor{
+		Cluster: func(c *Cluster) error { return boom },
+	})
+	if err != boom {
+		t.Fatalf("was expecting the visitor error but got %v", err)
+	}
+}
+
+func TestWalkMalformed(t *testing.T) {
+	t.Parallel()
+	err := Walk(strings.NewReader(document[:300]), Visitor{})
+	if err == nil {
+		t.Fatal("was expecting an error for a truncated document")
+	}
+}
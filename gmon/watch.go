@@ -0,0 +1,297 @@
+package gmon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidInterval = errors.New("gmon: watch interval must be positive")
+	errInvalidTimeout  = errors.New("gmon: watch timeout must be positive")
+)
+
+// The longest delay between polls after read errors, unless the interval is
+// longer.
+const maxBackoff = 5 * time.Minute
+
+// Source provides the snapshots polled by Watch. It must return once the
+// context is done.
+type Source func(ctx context.Context) (*Ganglia, error)
+
+// RemoteSource returns a Source reading from the gmond or gmetad at the given
+// network/address.
+func RemoteSource(network, addr string) Source {
+	return func(ctx context.Context) (*Ganglia, error) {
+		var d net.Dialer
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			if err := c.SetDeadline(deadline); err != nil {
+				return nil, err
+			}
+		}
+		// Unblock the read if the context is cancelled.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.Close()
+			case <-done:
+			}
+		}()
+		return Read(bufio.NewReader(c))
+	}
+}
+
+// EventKind identifies what changed.
+type EventKind int
+
+// The kinds of events.
+const (
+	HostAppeared EventKind = iota
+	HostDisappeared
+	HostDown
+	HostRecovered
+	MetricAdded
+	MetricRemoved
+	MetricChanged
+	MetadataChanged
+	ReadFailed
+)
+
+var eventKindNames = map[EventKind]string{
+	HostAppeared:    "host appeared",
+	HostDisappeared: "host disappeared",
+	HostDown:        "host down",
+	HostRecovered:   "host recovered",
+	MetricAdded:     "metric added",
+	MetricRemoved:   "metric removed",
+	MetricChanged:   "metric changed",
+	MetadataChanged: "metadata changed",
+	ReadFailed:      "read failed",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event describes a change between two snapshots, or a failed read.
+type Event struct {
+	Kind EventKind
+
+	// The time the snapshot was read.
+	Time time.Time
+
+	// The cluster and host the event is for, and the metric for metric
+	// events.
+	Cluster string
+	Host    string
+	Metric  string
+
+	// The metric in the previous and the current snapshot, for metric events.
+	// Old is nil for MetricAdded and New is nil for MetricRemoved.
+	Old *Metric
+	New *Metric
+
+	// The error for ReadFailed.
+	Err error
+}
+
+// Watcher polls a Source and emits the changes as Events.
+type Watcher struct {
+	events chan Event
+
+	mu       sync.Mutex
+	snapshot *Ganglia
+	at       time.Time
+}
+
+// Watch polls the Source every interval until the context is done, emitting
+// an Event for every change between consecutive snapshots. The first snapshot
+// is the baseline and emits no events. Metrics of hosts that appear or
+// disappear only emit the host event. A metric whose metadata and value both
+// changed emits MetadataChanged followed by MetricChanged. After a failed
+// read a ReadFailed Event is emitted and the delay before the next poll
+// doubles, up to 5 minutes or the interval if longer. Each poll may take at
+// most the timeout, a poll that takes longer is cancelled and fails. The
+// interval and timeout must be positive.
+func Watch(ctx context.Context, source Source, interval, timeout time.Duration) (*Watcher, error) {
+	if interval <= 0 {
+		return nil, errInvalidInterval
+	}
+	if timeout <= 0 {
+		return nil, errInvalidTimeout
+	}
+	w := &Watcher{events: make(chan Event, 64)}
+	go w.run(ctx, source, interval, timeout)
+	return w, nil
+}
+
+// Events returns the channel of events, which is closed once the context is
+// done.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Snapshot returns the last snapshot read and the time it was read, or nil if
+// none has been read yet. It must not be modified.
+func (w *Watcher) Snapshot() (*Ganglia, time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snapshot, w.at
+}
+
+func (w *Watcher) run(ctx context.Context, source Source, interval, timeout time.Duration) {
+	defer close(w.events)
+	var previous *Index
+	failures := 0
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, timeout)
+		g, err := source(pollCtx)
+		cancel()
+		at := time.Now()
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := interval
+		if err != nil {
+			failures++
+			delay = backoff(interval, failures)
+			if !w.emit(ctx, []Event{{Kind: ReadFailed, Time: at, Err: err}}) {
+				return
+			}
+		} else {
+			failures = 0
+			current := NewIndex(g)
+			w.mu.Lock()
+			w.snapshot, w.at = g, at
+			w.mu.Unlock()
+			if previous != nil && !w.emit(ctx, diff(previous, current, at)) {
+				return
+			}
+			previous = current
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Sends the events, and reports false if the context is done first.
+func (w *Watcher) emit(ctx context.Context, events []Event) bool {
+	for _, e := range events {
+		select {
+		case w.events <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func backoff(interval time.Duration, failures int) time.Duration {
+	limit := maxBackoff
+	if interval > limit {
+		limit = interval
+	}
+	delay := interval
+	for i := 0; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// Returns the events for the changes between the snapshots, in sorted order
+// of cluster, host and metric.
+func diff(old, cur *Index, at time.Time) []Event {
+	var events []Event
+	for _, c := range cur.Clusters() {
+		for _, h := range cur.Hosts(c.Name) {
+			oh := old.Host(c.Name, h.Name)
+			event := Event{Time: at, Cluster: c.Name, Host: h.Name}
+			if oh == nil {
+				event.Kind = HostAppeared
+				events = append(events, event)
+				continue
+			}
+			if down := h.IsDown(); down != oh.IsDown() {
+				event.Kind = HostRecovered
+				if down {
+					event.Kind = HostDown
+				}
+				events = append(events, event)
+			}
+			events = append(events, diffMetrics(old, cur, event)...)
+		}
+	}
+	for _, c := range old.Clusters() {
+		for _, h := range old.Hosts(c.Name) {
+			if cur.Host(c.Name, h.Name) == nil {
+				events = append(events, Event{
+					Kind:    HostDisappeared,
+					Time:    at,
+					Cluster: c.Name,
+					Host:    h.Name,
+				})
+			}
+		}
+	}
+	return events
+}
+
+func diffMetrics(old, cur *Index, host Event) []Event {
+	var events []Event
+	for _, m := range cur.Metrics(host.Cluster, host.Host) {
+		event := host
+		event.Metric, event.New = m.Name, m
+		event.Old = old.Metric(host.Cluster, host.Host, m.Name)
+		if event.Old == nil {
+			event.Kind = MetricAdded
+			events = append(events, event)
+			continue
+		}
+		if metadataChanged(event.Old, m) {
+			event.Kind = MetadataChanged
+			events = append(events, event)
+		}
+		if event.Old.Value != m.Value {
+			event.Kind = MetricChanged
+			events = append(events, event)
+		}
+	}
+	for _, m := range old.Metrics(host.Cluster, host.Host) {
+		if cur.Metric(host.Cluster, host.Host, m.Name) == nil {
+			event := host
+			event.Kind, event.Metric, event.Old = MetricRemoved, m.Name, m
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func metadataChanged(a, b *Metric) bool {
+	return a.Type != b.Type || a.Unit != b.Unit || a.Slope != b.Slope ||
+		a.Tmax != b.Tmax || a.Dmax != b.Dmax || a.Source != b.Source ||
+		!reflect.DeepEqual(a.ExtraData, b.ExtraData)
+}
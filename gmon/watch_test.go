@@ -0,0 +1,227 @@
+package gmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func watchHost(name string, tn int, metrics ...Metric) Host {
+	return Host{Name: name, IP: "10.0.0.1", Tn: tn, Tmax: 20, Metrics: metrics}
+}
+
+func watchMetric(name, value string) Metric {
+	return Metric{Name: name, Value: value, Type: "float", Tmax: 60}
+}
+
+// scriptedSource returns the snapshots in order, repeating the last one.
+func scriptedSource(snapshots ...*Ganglia) Source {
+	var n int
+	return func(ctx context.Context) (*Ganglia, error) {
+		g := snapshots[n]
+		if n < len(snapshots)-1 {
+			n++
+		}
+		if g == nil {
+			return nil, errors.New("unavailable")
+		}
+		return g, nil
+	}
+}
+
+func eventString(e Event) string {
+	return fmt.Sprintf("%s %s/%s/%s", e.Kind, e.Cluster, e.Host, e.Metric)
+}
+
+func TestWatchEvents(t *testing.T) {
+	t.Parallel()
+	changed := watchMetric("load", "1")
+	changed.Unit = "procs"
+	snapshots := []*Ganglia{
+		{Clusters: []Cluster{{Name: "web", Hosts: []Host{
+			watchHost("a", 0, watchMetric("cpu", "1"), watchMetric("load", "1")),
+			watchHost("b", 0),
+			watchHost("c", 0),
+		}}}},
+		{Clusters: []Cluster{{Name: "web", Hosts: []Host{
+			watchHost("a", 0, watchMetric("cpu", "2"), changed, watchMetric("mem", "3")),
+			watchHost("b", 100),
+			watchHost("d", 0),
+		}}}},
+		nil,
+		{Clusters: []Cluster{{Name: "web", Hosts: []Host{
+			watchHost("a", 0, watchMetric("cpu", "2")),
+			watchHost("b", 0),
+			watchHost("d", 0),
+		}}}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := Watch(ctx, scriptedSource(snapshots...), time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"metric changed web/a/cpu",
+		"metadata changed web/a/load",
+		"metric added web/a/mem",
+		"host down web/b/",
+		"host appeared web/d/",
+		"host disappeared web/c/",
+		"read failed //",
+		"metric removed web/a/load",
+		"metric removed web/a/mem",
+		"host recovered web/b/",
+	}
+	for _, e := range expected {
+		actual := eventString(<-w.Events())
+		if actual != e {
+			t.Fatalf("was expecting %q but got %q", e, actual)
+		}
+	}
+
+	g, at := w.Snapshot()
+	if g != snapshots[3] || at.IsZero() {
+		t.Fatalf("was expecting the last snapshot but got %v at %v", g, at)
+	}
+	cancel()
+	for e := range w.Events() {
+		t.Fatalf("was expecting no more events but got %q", eventString(e))
+	}
+}
+
+func TestWatchEventValues(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := Watch(ctx, scriptedSource(
+		&Ganglia{Hosts: []Host{watchHost("a", 0, watchMetric("cpu", "1"))}},
+		&Ganglia{Hosts: []Host{watchHost("a", 0, watchMetric("cpu", "2"))}},
+	), time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := <-w.Events()
+	if e.Kind != MetricChanged || e.Old.Value != "1" || e.New.Value != "2" {
+		t.Fatalf("was expecting the old and new metric but got %+v", e)
+	}
+	if e.Time.IsZero() {
+		t.Fatal("was expecting the event time")
+	}
+}
+
+func TestWatchMetadataAndValueChanged(t *testing.T) {
+	t.Parallel()
+	changed := watchMetric("cpu", "2")
+	changed.Unit = "%"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := Watch(ctx, scriptedSource(
+		&Ganglia{Hosts: []Host{watchHost("a", 0, watchMetric("cpu", "1"))}},
+		&Ganglia{Hosts: []Host{watchHost("a", 0, changed)}},
+	), time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []EventKind{MetadataChanged, MetricChanged} {
+		e := <-w.Events()
+		if e.Kind != kind || e.Old.Value != "1" || e.New.Value != "2" {
+			t.Fatalf("was expecting %s with the old and new metric but got %+v", kind, e)
+		}
+	}
+}
+
+func TestWatchSnapshotBeforeRead(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan struct{})
+	w, err := Watch(ctx, func(ctx context.Context) (*Ganglia, error) {
+		<-block
+		return nil, ctx.Err()
+	}, time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := w.Snapshot(); g != nil {
+		t.Fatalf("was expecting no snapshot but got %v", g)
+	}
+	cancel()
+	close(block)
+	if _, ok := <-w.Events(); ok {
+		t.Fatal("was expecting the events to be closed")
+	}
+}
+
+func TestWatchPollTimeout(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := Watch(ctx, func(ctx context.Context) (*Ganglia, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, time.Hour, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.Events():
+		if e.Kind != ReadFailed || e.Err != context.DeadlineExceeded {
+			t.Fatalf("was expecting a timed out read but got %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("was expecting the hung poll to time out")
+	}
+}
+
+func TestWatchInvalidInterval(t *testing.T) {
+	t.Parallel()
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := Watch(context.Background(), scriptedSource(nil), interval, time.Second); err != errInvalidInterval {
+			t.Fatalf("was expecting errInvalidInterval for %s but got %v", interval, err)
+		}
+		if _, err := Watch(context.Background(), scriptedSource(nil), time.Second, interval); err != errInvalidTimeout {
+			t.Fatalf("was expecting errInvalidTimeout for %s but got %v", interval, err)
+		}
+	}
+}
+
+func TestWatchBackoff(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		interval time.Duration
+		failures int
+		expected time.Duration
+	}{
+		{time.Second, 1, 2 * time.Second},
+		{time.Second, 3, 8 * time.Second},
+		{time.Second, 20, maxBackoff},
+		{10 * time.Minute, 2, 10 * time.Minute},
+	}
+	for _, c := range cases {
+		if actual := backoff(c.interval, c.failures); actual != c.expected {
+			t.Fatalf("was expecting %s for %s after %d failures but got %s",
+				c.expected, c.interval, c.failures, actual)
+		}
+	}
+}
+
+func TestRemoteSourceError(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := RemoteSource("tcp", "127.0.0.1:1")(ctx)
+	if err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Fatalf("was expecting a cancelled error but got %v", err)
+	}
+}
+
+func TestEventKindString(t *testing.T) {
+	t.Parallel()
+	if HostDown.String() != "host down" || EventKind(100).String() != "unknown" {
+		t.Fatal("was expecting the event kind names")
+	}
+}
@@ -0,0 +1,315 @@
+package gmon
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const header = `<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?>
+<!DOCTYPE GANGLIA_XML [
+   <!ELEMENT GANGLIA_XML (GRID|CLUSTER|HOST)*>
+      <!ATTLIST GANGLIA_XML VERSION CDATA #REQUIRED>
+      <!ATTLIST GANGLIA_XML SOURCE CDATA #REQUIRED>
+   <!ELEMENT GRID (CLUSTER | GRID | HOSTS | METRICS)*>
+      <!ATTLIST GRID NAME CDATA #REQUIRED>
+      <!ATTLIST GRID AUTHORITY CDATA #REQUIRED>
+      <!ATTLIST GRID LOCALTIME CDATA #IMPLIED>
+   <!ELEMENT CLUSTER (HOST | HOSTS | METRICS)*>
+      <!ATTLIST CLUSTER NAME CDATA #REQUIRED>
+      <!ATTLIST CLUSTER OWNER CDATA #IMPLIED>
+      <!ATTLIST CLUSTER LATLONG CDATA #IMPLIED>
+      <!ATTLIST CLUSTER URL CDATA #IMPLIED>
+      <!ATTLIST CLUSTER LOCALTIME CDATA #REQUIRED>
+   <!ELEMENT HOST (METRIC)*>
+      <!ATTLIST HOST NAME CDATA #REQUIRED>
+      <!ATTLIST HOST IP CDATA #REQUIRED>
+      <!ATTLIST HOST LOCATION CDATA #IMPLIED>
+      <!ATTLIST HOST TAGS CDATA #IMPLIED>
+      <!ATTLIST HOST REPORTED CDATA #REQUIRED>
+      <!ATTLIST HOST TN CDATA #IMPLIED>
+      <!ATTLIST HOST TMAX CDATA #IMPLIED>
+      <!ATTLIST HOST DMAX CDATA #IMPLIED>
+      <!ATTLIST HOST GMOND_STARTED CDATA #IMPLIED>
+   <!ELEMENT METRIC (EXTRA_DATA*)>
+      <!ATTLIST METRIC NAME CDATA #REQUIRED>
+      <!ATTLIST METRIC VAL CDATA #REQUIRED>
+      <!ATTLIST METRIC TYPE (string | int8 | uint8 | int16 | uint16 | int32 | uint32 | float | double | timestamp) #REQUIRED>
+      <!ATTLIST METRIC UNITS CDATA #IMPLIED>
+      <!ATTLIST METRIC TN CDATA #IMPLIED>
+      <!ATTLIST METRIC TMAX CDATA #IMPLIED>
+      <!ATTLIST METRIC DMAX CDATA #IMPLIED>
+      <!ATTLIST METRIC SLOPE (zero | positive | negative | both | unspecified) #IMPLIED>
+      <!ATTLIST METRIC SOURCE (gmond) 'gmond'>
+   <!ELEMENT EXTRA_DATA (EXTRA_ELEMENT*)>
+   <!ELEMENT EXTRA_ELEMENT EMPTY>
+      <!ATTLIST EXTRA_ELEMENT NAME CDATA #REQUIRED>
+      <!ATTLIST EXTRA_ELEMENT VAL CDATA #REQUIRED>
+   <!ELEMENT HOSTS EMPTY>
+      <!ATTLIST HOSTS UP CDATA #REQUIRED>
+      <!ATTLIST HOSTS DOWN CDATA #REQUIRED>
+      <!ATTLIST HOSTS SOURCE (gmond | gmetad) #REQUIRED>
+   <!ELEMENT METRICS (EXTRA_DATA*)>
+      <!ATTLIST METRICS NAME CDATA #REQUIRED>
+      <!ATTLIST METRICS SUM CDATA #REQUIRED>
+      <!ATTLIST METRICS NUM CDATA #REQUIRED>
+      <!ATTLIST METRICS TYPE (string | int8 | uint8 | int16 | uint16 | int32 | uint32 | float | double | timestamp) #REQUIRED>
+      <!ATTLIST METRICS UNITS CDATA #IMPLIED>
+      <!ATTLIST METRICS SLOPE (zero | positive | negative | both | unspecified) #IMPLIED>
+      <!ATTLIST METRICS SOURCE (gmond) 'gmond'>
+]>
+`
+
+// Write the document as gmond or gmetad XML output, with the DTD header and
+// the attributes in the order gmond and gmetad write them. UNITS and TAGS are
+// always written like gmond does, other empty optional attributes are
+// omitted and an empty SOURCE defaults to "gmond", so the output is valid
+// against the DTD. The document is checked before anything is written: a
+// metric or summary metric without a valid TYPE or with an invalid SLOPE,
+// or a host with EXTRA_DATA, which the DTD does not allow, is an error.
+func Write(w io.Writer, g *Ganglia) error {
+	if err := check(g); err != nil {
+		return err
+	}
+	x := &xmlWriter{w: bufio.NewWriter(w)}
+	x.w.WriteString(header)
+	x.start("GANGLIA_XML", "VERSION", g.Version, "SOURCE", g.Source)
+	for i := range g.Grids {
+		x.grid(&g.Grids[i])
+	}
+	for i := range g.Clusters {
+		x.cluster(&g.Clusters[i])
+	}
+	for i := range g.Hosts {
+		x.host(&g.Hosts[i])
+	}
+	x.end("GANGLIA_XML")
+	return x.w.Flush()
+}
+
+// Checks the document against the DTD where the types allow values it does
+// not.
+func check(g *Ganglia) error {
+	for i := range g.Grids {
+		if err := checkGrid(&g.Grids[i]); err != nil {
+			return err
+		}
+	}
+	return checkClusters(g.Clusters, g.Hosts)
+}
+
+func checkGrid(g *Grid) error {
+	if err := checkSummary(g.SummaryMetrics); err != nil {
+		return err
+	}
+	for i := range g.Grids {
+		if err := checkGrid(&g.Grids[i]); err != nil {
+			return err
+		}
+	}
+	return checkClusters(g.Clusters, nil)
+}
+
+func checkClusters(clusters []Cluster, hosts []Host) error {
+	for i := range clusters {
+		c := &clusters[i]
+		if err := checkClusters(nil, c.Hosts); err != nil {
+			return err
+		}
+		if err := checkSummary(c.SummaryMetrics); err != nil {
+			return err
+		}
+	}
+	for i := range hosts {
+		h := &hosts[i]
+		if len(h.ExtraData.ExtraElements) > 0 {
+			return fmt.Errorf("gmon: EXTRA_DATA on HOST %s is not allowed by the DTD", h.Name)
+		}
+		for j := range h.Metrics {
+			m := &h.Metrics[j]
+			if err := checkEnums("METRIC", m.Name, m.Type, m.Slope); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkSummary(metrics []SummaryMetric) error {
+	for i := range metrics {
+		m := &metrics[i]
+		if err := checkEnums("METRICS", m.Name, m.Type, m.Slope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the required TYPE and the optional SLOPE of a metric against the
+// DTD.
+func checkEnums(element, name, typ, slope string) error {
+	if !contains(enums["TYPE"], typ) {
+		return fmt.Errorf("gmon: invalid TYPE %q on %s %s", typ, element, name)
+	}
+	if slope != "" && !contains(enums["SLOPE"], slope) {
+		return fmt.Errorf("gmon: invalid SLOPE %q on %s %s", slope, element, name)
+	}
+	return nil
+}
+
+// xmlWriter writes the elements of a checked document. Write errors are
+// sticky in the bufio.Writer and returned by Flush.
+type xmlWriter struct {
+	w *bufio.Writer
+}
+
+func (x *xmlWriter) grid(g *Grid) {
+	x.start("GRID", "NAME", g.Name, "AUTHORITY", g.Authority, "LOCALTIME", itoa(g.Localtime))
+	x.summary(g.Summary, g.SummaryMetrics)
+	for i := range g.Clusters {
+		x.cluster(&g.Clusters[i])
+	}
+	for i := range g.Grids {
+		x.grid(&g.Grids[i])
+	}
+	x.end("GRID")
+}
+
+func (x *xmlWriter) cluster(c *Cluster) {
+	attrs := []string{"NAME", c.Name, "LOCALTIME", itoa(c.Localtime)}
+	attrs = appendImplied(attrs, "OWNER", c.Owner)
+	attrs = appendImplied(attrs, "LATLONG", c.LatLong)
+	attrs = appendImplied(attrs, "URL", c.URL)
+	x.start("CLUSTER", attrs...)
+	for i := range c.Hosts {
+		x.host(&c.Hosts[i])
+	}
+	x.summary(c.Summary, c.SummaryMetrics)
+	x.end("CLUSTER")
+}
+
+func (x *xmlWriter) host(h *Host) {
+	attrs := []string{
+		"NAME", h.Name,
+		"IP", h.IP,
+		"TAGS", h.Tags,
+		"REPORTED", itoa(h.Reported),
+		"TN", itoa(h.Tn),
+		"TMAX", itoa(h.Tmax),
+		"DMAX", itoa(h.Dmax),
+	}
+	attrs = appendImplied(attrs, "LOCATION", h.Location)
+	attrs = append(attrs, "GMOND_STARTED", itoa(h.GmondStarted))
+	x.start("HOST", attrs...)
+	for i := range h.Metrics {
+		x.metric(&h.Metrics[i])
+	}
+	x.end("HOST")
+}
+
+func (x *xmlWriter) metric(m *Metric) {
+	attrs := []string{
+		"NAME", m.Name,
+		"VAL", m.Value,
+		"TYPE", m.Type,
+		"UNITS", m.Unit,
+		"TN", itoa(m.Tn),
+		"TMAX", itoa(m.Tmax),
+		"DMAX", itoa(m.Dmax),
+	}
+	attrs = appendImplied(attrs, "SLOPE", m.Slope)
+	attrs = append(attrs, "SOURCE", source(m.Source))
+	x.element("METRIC", attrs, &m.ExtraData)
+}
+
+func (x *xmlWriter) summary(hosts *HostsSummary, metrics []SummaryMetric) {
+	if hosts != nil {
+		x.empty("HOSTS", "UP", itoa(hosts.Up), "DOWN", itoa(hosts.Down), "SOURCE", source(hosts.Source))
+	}
+	for i := range metrics {
+		m := &metrics[i]
+		attrs := []string{
+			"NAME", m.Name,
+			"SUM", strconv.FormatFloat(m.Sum, 'f', -1, 64),
+			"NUM", itoa(m.Num),
+			"TYPE", m.Type,
+			"UNITS", m.Unit,
+		}
+		attrs = appendImplied(attrs, "SLOPE", m.Slope)
+		attrs = append(attrs, "SOURCE", source(m.Source))
+		x.element("METRICS", attrs, &m.ExtraData)
+	}
+}
+
+// Writes an element which only contains the extra data, if any.
+func (x *xmlWriter) element(name string, attrs []string, e *ExtraData) {
+	if len(e.ExtraElements) == 0 {
+		x.empty(name, attrs...)
+		return
+	}
+	x.start(name, attrs...)
+	x.extraData(e)
+	x.end(name)
+}
+
+func (x *xmlWriter) extraData(e *ExtraData) {
+	if len(e.ExtraElements) == 0 {
+		return
+	}
+	x.start("EXTRA_DATA")
+	for _, el := range e.ExtraElements {
+		x.empty("EXTRA_ELEMENT", "NAME", el.Name, "VAL", el.Val)
+	}
+	x.end("EXTRA_DATA")
+}
+
+func (x *xmlWriter) start(name string, attrs ...string) {
+	x.open(name, attrs)
+	x.w.WriteString(">\n")
+}
+
+func (x *xmlWriter) empty(name string, attrs ...string) {
+	x.open(name, attrs)
+	x.w.WriteString("/>\n")
+}
+
+func (x *xmlWriter) end(name string) {
+	x.w.WriteString("</")
+	x.w.WriteString(name)
+	x.w.WriteString(">\n")
+}
+
+// Writes the element name and the attributes, given as name and value pairs.
+func (x *xmlWriter) open(name string, attrs []string) {
+	x.w.WriteByte('<')
+	x.w.WriteString(name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		x.w.WriteByte(' ')
+		x.w.WriteString(attrs[i])
+		x.w.WriteString(`="`)
+		xml.EscapeText(x.w, []byte(attrs[i+1]))
+		x.w.WriteByte('"')
+	}
+}
+
+// Appends the #IMPLIED attribute unless it is empty.
+func appendImplied(attrs []string, name, value string) []string {
+	if value == "" {
+		return attrs
+	}
+	return append(attrs, name, value)
+}
+
+// Returns the SOURCE, which defaults to gmond like in the DTD.
+func source(s string) string {
+	if s == "" {
+		return "gmond"
+	}
+	return s
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
@@ -0,0 +1,177 @@
+package gmon
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, doc := range []string{document, gmetadSummary, gmondOutput} {
+		expected, err := Read(strings.NewReader(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, expected); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ReadStrict(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("was expecting valid output but got %s\n%s", err, buf.String())
+		}
+		defaultSources(expected)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("was expecting\n%+v\nbut got\n%+v", expected, actual)
+		}
+	}
+}
+
+// Sets the empty metric SOURCEs to gmond, as Write does.
+func defaultSources(g *Ganglia) {
+	hosts := g.Hosts
+	for _, c := range g.AllClusters() {
+		hosts = append(hosts, c.Hosts...)
+	}
+	for _, h := range hosts {
+		for i := range h.Metrics {
+			if h.Metrics[i].Source == "" {
+				h.Metrics[i].Source = "gmond"
+			}
+		}
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	t.Parallel()
+	g := &Ganglia{
+		Version: "3.6.0",
+		Source:  "gmond",
+		Clusters: []Cluster{{
+			Name:      "c",
+			Localtime: 10,
+			Hosts: []Host{{
+				Name: "h",
+				IP:   "10.0.0.1",
+				Metrics: []Metric{{
+					Name:      "m",
+					Value:     `a "quoted" <value> & more`,
+					Type:      "string",
+					Slope:     "zero",
+					Source:    "gmond",
+					ExtraData: ExtraData{ExtraElements: []ExtraElement{{Name: "GROUP", Val: "g"}}},
+				}},
+			}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, `<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?>`+"\n<!DOCTYPE GANGLIA_XML [") {
+		t.Fatalf("was expecting the declaration and DTD but got\n%s", out)
+	}
+	expected := `]>
+<GANGLIA_XML VERSION="3.6.0" SOURCE="gmond">
+<CLUSTER NAME="c" LOCALTIME="10">
+<HOST NAME="h" IP="10.0.0.1" TAGS="" REPORTED="0" TN="0" TMAX="0" DMAX="0" GMOND_STARTED="0">
+<METRIC NAME="m" VAL="a &#34;quoted&#34; &lt;value&gt; &amp; more" TYPE="string" UNITS="" TN="0" TMAX="0" DMAX="0" SLOPE="zero" SOURCE="gmond">
+<EXTRA_DATA>
+<EXTRA_ELEMENT NAME="GROUP" VAL="g"/>
+</EXTRA_DATA>
+</METRIC>
+</HOST>
+</CLUSTER>
+</GANGLIA_XML>
+`
+	if !strings.HasSuffix(out, expected) {
+		t.Fatalf("was expecting the document to end with\n%s\nbut got\n%s", expected, out)
+	}
+}
+
+func TestWriteRoundTripZeroValues(t *testing.T) {
+	t.Parallel()
+	g := &Ganglia{
+		Grids: []Grid{{
+			Summary:        &HostsSummary{},
+			SummaryMetrics: []SummaryMetric{{Name: "s", Type: "double"}},
+			Clusters: []Cluster{{
+				Hosts: []Host{{Metrics: []Metric{{Name: "m", Type: "uint32"}}}},
+			}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ReadStrict(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("was expecting valid output but got %s\n%s", err, buf.String())
+	}
+
+	// Only the SOURCE changes, to the default of the DTD.
+	g.Grids[0].Summary.Source = "gmond"
+	g.Grids[0].SummaryMetrics[0].Source = "gmond"
+	g.Grids[0].Clusters[0].Hosts[0].Metrics[0].Source = "gmond"
+	if !reflect.DeepEqual(actual, g) {
+		t.Fatalf("was expecting\n%+v\nbut got\n%+v", g, actual)
+	}
+}
+
+func TestWriteInvalidEnums(t *testing.T) {
+	t.Parallel()
+	cases := map[string]*Ganglia{
+		`gmon: invalid TYPE "" on METRIC m`: {
+			Hosts: []Host{{Metrics: []Metric{{Name: "m"}}}},
+		},
+		`gmon: invalid SLOPE "up" on METRIC m`: {
+			Hosts: []Host{{Metrics: []Metric{{Name: "m", Type: "float", Slope: "up"}}}},
+		},
+		`gmon: invalid TYPE "int64" on METRICS s`: {
+			Grids: []Grid{{SummaryMetrics: []SummaryMetric{{Name: "s", Type: "int64"}}}},
+		},
+		`gmon: EXTRA_DATA on HOST h is not allowed by the DTD`: {
+			Hosts: []Host{{Name: "h", ExtraData: ExtraData{ExtraElements: []ExtraElement{{Name: "k", Val: "v"}}}}},
+		},
+	}
+	for expected, g := range cases {
+		if err := Write(&bytes.Buffer{}, g); err == nil || err.Error() != expected {
+			t.Fatalf("was expecting error %q but got %v", expected, err)
+		}
+	}
+}
+
+func TestWriteInvalidWritesNothing(t *testing.T) {
+	t.Parallel()
+	// Enough valid metrics to fill the buffer before the invalid one.
+	metrics := make([]Metric, 1000)
+	for i := range metrics {
+		metrics[i] = Metric{Name: "m", Type: "uint32"}
+	}
+	metrics = append(metrics, Metric{Name: "bad", Type: "int64"})
+	g := &Ganglia{Clusters: []Cluster{{Hosts: []Host{{Metrics: metrics}}}}}
+	var buf bytes.Buffer
+	if err := Write(&buf, g); err == nil || !strings.Contains(err.Error(), "bad") {
+		t.Fatalf("was expecting an error for the invalid metric but got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("was expecting no output but got %d bytes", buf.Len())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+	if err := Write(failingWriter{}, &Ganglia{}); err == nil || err.Error() != "write failed" {
+		t.Fatalf("was expecting the write error but got %v", err)
+	}
+}
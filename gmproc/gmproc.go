@@ -0,0 +1,832 @@
+// Package gmproc collects the gmond core host metrics from a Linux /proc
+// tree. The metrics use the same names, types and units as the gmond C
+// modules so existing gweb reports continue to work.
+package gmproc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// The core metrics as defined by the gmond linux module.
+var (
+	CPUNum = &gmetric.Metric{
+		Name:      "cpu_num",
+		Title:     "CPU Count",
+		ValueType: gmetric.ValueUint16,
+		Units:     "CPUs",
+		Slope:     gmetric.SlopeZero,
+		Groups:    []string{"cpu"},
+	}
+	CPUUser = &gmetric.Metric{
+		Name:        "cpu_user",
+		Title:       "CPU User",
+		Description: "Percentage of CPU utilization that occurred while executing at the user level",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "%",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"cpu"},
+	}
+	CPUNice = &gmetric.Metric{
+		Name:        "cpu_nice",
+		Title:       "CPU Nice",
+		Description: "Percentage of CPU utilization that occurred while executing at the user level with nice priority",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "%",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"cpu"},
+	}
+	CPUSystem = &gmetric.Metric{
+		Name:        "cpu_system",
+		Title:       "CPU System",
+		Description: "Percentage of CPU utilization that occurred while executing at the system level",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "%",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"cpu"},
+	}
+	CPUIdle = &gmetric.Metric{
+		Name:        "cpu_idle",
+		Title:       "CPU Idle",
+		Description: "Percentage of time that the CPU or CPUs were idle and the system did not have an outstanding disk I/O request",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "%",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"cpu"},
+	}
+	CPUWio = &gmetric.Metric{
+		Name:        "cpu_wio",
+		Title:       "CPU wio",
+		Description: "Percentage of time that the CPU or CPUs were idle during which the system had an outstanding disk I/O request",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "%",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"cpu"},
+	}
+	CPUAidle = &gmetric.Metric{
+		Name:        "cpu_aidle",
+		Title:       "CPU aidle",
+		Description: "Percent of time since boot idle CPU",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "%",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"cpu"},
+	}
+	CPUIntr = &gmetric.Metric{
+		Name:      "cpu_intr",
+		Title:     "CPU intr",
+		ValueType: gmetric.ValueFloat32,
+		Units:     "%",
+		Slope:     gmetric.SlopeBoth,
+		Groups:    []string{"cpu"},
+	}
+	CPUSintr = &gmetric.Metric{
+		Name:      "cpu_sintr",
+		Title:     "CPU sintr",
+		ValueType: gmetric.ValueFloat32,
+		Units:     "%",
+		Slope:     gmetric.SlopeBoth,
+		Groups:    []string{"cpu"},
+	}
+	CPUSteal = &gmetric.Metric{
+		Name:      "cpu_steal",
+		Title:     "CPU steal",
+		ValueType: gmetric.ValueFloat32,
+		Units:     "%",
+		Slope:     gmetric.SlopeBoth,
+		Groups:    []string{"cpu"},
+	}
+	BootTime = &gmetric.Metric{
+		Name:        "boottime",
+		Title:       "Last Boot Time",
+		Description: "The last time that the system was started",
+		ValueType:   gmetric.ValueUint32,
+		Units:       "s",
+		Slope:       gmetric.SlopeZero,
+		Groups:      []string{"system"},
+	}
+	ProcRun = &gmetric.Metric{
+		Name:        "proc_run",
+		Title:       "Total Running Processes",
+		Description: "Total number of running processes",
+		ValueType:   gmetric.ValueUint32,
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"process"},
+	}
+	ProcTotal = &gmetric.Metric{
+		Name:        "proc_total",
+		Title:       "Total Processes",
+		Description: "Total number of processes",
+		ValueType:   gmetric.ValueUint32,
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"process"},
+	}
+	LoadOne = &gmetric.Metric{
+		Name:        "load_one",
+		Title:       "One Minute Load Average",
+		Description: "One minute load average",
+		ValueType:   gmetric.ValueFloat32,
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"load"},
+	}
+	LoadFive = &gmetric.Metric{
+		Name:        "load_five",
+		Title:       "Five Minute Load Average",
+		Description: "Five minute load average",
+		ValueType:   gmetric.ValueFloat32,
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"load"},
+	}
+	LoadFifteen = &gmetric.Metric{
+		Name:        "load_fifteen",
+		Title:       "Fifteen Minute Load Average",
+		Description: "Fifteen minute load average",
+		ValueType:   gmetric.ValueFloat32,
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"load"},
+	}
+	MemTotal = &gmetric.Metric{
+		Name:        "mem_total",
+		Title:       "Memory Total",
+		Description: "Total amount of memory displayed in KBs",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeZero,
+		Groups:      []string{"memory"},
+	}
+	MemFree = &gmetric.Metric{
+		Name:        "mem_free",
+		Title:       "Free Memory",
+		Description: "Amount of available memory",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"memory"},
+	}
+	MemShared = &gmetric.Metric{
+		Name:        "mem_shared",
+		Title:       "Shared Memory",
+		Description: "Amount of shared memory",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"memory"},
+	}
+	MemBuffers = &gmetric.Metric{
+		Name:        "mem_buffers",
+		Title:       "Memory Buffers",
+		Description: "Amount of buffered memory",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"memory"},
+	}
+	MemCached = &gmetric.Metric{
+		Name:        "mem_cached",
+		Title:       "Cached Memory",
+		Description: "Amount of cached memory",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"memory"},
+	}
+	MemSReclaimable = &gmetric.Metric{
+		Name:        "mem_sreclaimable",
+		Title:       "Slab Reclaimable",
+		Description: "Amount of reclaimable slab memory",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"memory"},
+	}
+	SwapFree = &gmetric.Metric{
+		Name:        "swap_free",
+		Title:       "Free Swap Space",
+		Description: "Amount of available swap memory",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"memory"},
+	}
+	SwapTotal = &gmetric.Metric{
+		Name:        "swap_total",
+		Title:       "Swap Space Total",
+		Description: "Total amount of swap space displayed in KBs",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "KB",
+		Slope:       gmetric.SlopeZero,
+		Groups:      []string{"memory"},
+	}
+	BytesIn = &gmetric.Metric{
+		Name:        "bytes_in",
+		Title:       "Bytes Received",
+		Description: "Number of bytes in per second",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "bytes/sec",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"network"},
+	}
+	BytesOut = &gmetric.Metric{
+		Name:        "bytes_out",
+		Title:       "Bytes Sent",
+		Description: "Number of bytes out per second",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "bytes/sec",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"network"},
+	}
+	PktsIn = &gmetric.Metric{
+		Name:        "pkts_in",
+		Title:       "Packets Received",
+		Description: "Packets in per second",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "packets/sec",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"network"},
+	}
+	PktsOut = &gmetric.Metric{
+		Name:        "pkts_out",
+		Title:       "Packets Sent",
+		Description: "Packets out per second",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "packets/sec",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"network"},
+	}
+	DiskTotal = &gmetric.Metric{
+		Name:        "disk_total",
+		Title:       "Total Disk Space",
+		Description: "Total available disk space",
+		ValueType:   gmetric.ValueFloat64,
+		Units:       "GB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"disk"},
+	}
+	DiskFree = &gmetric.Metric{
+		Name:        "disk_free",
+		Title:       "Disk Space Available",
+		Description: "Total free disk space",
+		ValueType:   gmetric.ValueFloat64,
+		Units:       "GB",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"disk"},
+	}
+	PartMaxUsed = &gmetric.Metric{
+		Name:        "part_max_used",
+		Title:       "Maximum Disk Space Used",
+		Description: "Maximum percent used for all partitions",
+		ValueType:   gmetric.ValueFloat32,
+		Units:       "%",
+		Slope:       gmetric.SlopeBoth,
+		Groups:      []string{"disk"},
+	}
+)
+
+// These are the metrics with a fixed name. Per device disk metrics are
+// defined on demand as devices are discovered.
+var coreMetrics = []*gmetric.Metric{
+	CPUNum, CPUUser, CPUNice, CPUSystem, CPUIdle, CPUWio, CPUAidle, CPUIntr,
+	CPUSintr, CPUSteal, BootTime, ProcRun, ProcTotal, LoadOne, LoadFive,
+	LoadFifteen, MemTotal, MemFree, MemShared, MemBuffers, MemCached,
+	MemSReclaimable, SwapFree, SwapTotal, BytesIn, BytesOut, PktsIn, PktsOut,
+	DiskTotal, DiskFree, PartMaxUsed,
+}
+
+// The unit used for disk_total and disk_free, gmond uses GB of 1e9 bytes.
+const gigabyte = 1e9
+
+// Collector reads the /proc tree and produces samples for the gmond core
+// metrics. The rate based metrics such as bytes_in are derived from the
+// difference between consecutive calls to Collect, and are omitted on the
+// first call. It implements gmetric.Collector.
+type Collector struct {
+	// The root of the proc filesystem. Defaults to /proc.
+	Root string
+
+	// Statfs returns the total and available bytes for the filesystem mounted
+	// at the given path. It defaults to using statfs(2) on the path as is,
+	// which may need to be overridden when Root is not the hosts /proc.
+	Statfs func(path string) (total, avail uint64, err error)
+
+	mu       sync.Mutex
+	now      func() time.Time
+	lastCPU  *cpuTimes
+	lastNet  *netCounters
+	lastDisk map[string]diskCounters
+	disks    map[string]*diskMetrics
+}
+
+var _ gmetric.Collector = (*Collector)(nil)
+
+// Metrics returns the metrics with a fixed name provided by the Collector.
+// Per device disk I/O metrics are discovered by Collect.
+func (c *Collector) Metrics() []*gmetric.Metric {
+	return coreMetrics
+}
+
+// Collect reads the configured proc tree. The returned samples may be
+// partial in which case the error will be a MultiError describing the files
+// that could not be read.
+func (c *Collector) Collect() ([]gmetric.Sample, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	at := now()
+
+	var samples []gmetric.Sample
+	var errs gmetric.MultiError
+	sources := []func(time.Time) ([]gmetric.Sample, error){
+		c.stat,
+		c.loadavg,
+		c.meminfo,
+		c.netdev,
+		c.diskstats,
+		c.mounts,
+	}
+	for _, source := range sources {
+		s, err := source(at)
+		samples = append(samples, s...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return samples, nil
+	}
+	return samples, errs
+}
+
+// Publish collects the metrics and writes the metadata and value for each of
+// them to the given Client.
+func (c *Collector) Publish(client *gmetric.Client) error {
+	samples, err := c.Collect()
+	var errs gmetric.MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, s := range samples {
+		if err := client.WriteMeta(s.Metric); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := client.WriteValue(s.Metric, s.Value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (c *Collector) path(name string) string {
+	root := c.Root
+	if root == "" {
+		root = "/proc"
+	}
+	return filepath.Join(root, name)
+}
+
+func (c *Collector) readLines(name string) ([][]string, error) {
+	b, err := ioutil.ReadFile(c.path(name))
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		lines = append(lines, strings.Fields(s.Text()))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+type cpuTimes struct {
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+func (t *cpuTimes) total() uint64 {
+	return t.user + t.nice + t.system + t.idle + t.iowait + t.irq + t.softirq +
+		t.steal
+}
+
+func (c *Collector) stat(at time.Time) ([]gmetric.Sample, error) {
+	lines, err := c.readLines("stat")
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []gmetric.Sample
+	var cur *cpuTimes
+	var cpus uint16
+	for _, f := range lines {
+		if len(f) < 2 {
+			continue
+		}
+		switch {
+		case f[0] == "cpu":
+			var v [8]uint64
+			for i := 0; i < len(v) && i+1 < len(f); i++ {
+				if v[i], err = strconv.ParseUint(f[i+1], 10, 64); err != nil {
+					return nil, fmt.Errorf("gmproc: invalid cpu line in %s: %s", c.path("stat"), err)
+				}
+			}
+			cur = &cpuTimes{
+				user: v[0], nice: v[1], system: v[2], idle: v[3],
+				iowait: v[4], irq: v[5], softirq: v[6], steal: v[7],
+			}
+		case strings.HasPrefix(f[0], "cpu"):
+			cpus++
+		case f[0] == "btime":
+			if v, err := strconv.ParseUint(f[1], 10, 32); err == nil {
+				samples = append(samples, gmetric.Sample{Metric: BootTime, Value: v})
+			}
+		}
+	}
+	if cur == nil {
+		return samples, fmt.Errorf("gmproc: no cpu line in %s", c.path("stat"))
+	}
+	samples = append(samples, gmetric.Sample{Metric: CPUNum, Value: cpus})
+
+	if total := cur.total(); total > 0 {
+		samples = append(samples, gmetric.Sample{
+			Metric: CPUAidle,
+			Value:  percent(cur.idle, total),
+		})
+	}
+
+	// Without a previous sample the percentages are since boot.
+	prev := c.lastCPU
+	if prev == nil {
+		prev = &cpuTimes{}
+	}
+	c.lastCPU = cur
+	total := cur.total() - prev.total()
+	if cur.total() < prev.total() || total == 0 {
+		return samples, nil
+	}
+	return append(samples,
+		gmetric.Sample{Metric: CPUUser, Value: percent(cur.user-prev.user, total)},
+		gmetric.Sample{Metric: CPUNice, Value: percent(cur.nice-prev.nice, total)},
+		gmetric.Sample{Metric: CPUSystem, Value: percent(cur.system-prev.system, total)},
+		gmetric.Sample{Metric: CPUIdle, Value: percent(cur.idle-prev.idle, total)},
+		gmetric.Sample{Metric: CPUWio, Value: percent(cur.iowait-prev.iowait, total)},
+		gmetric.Sample{Metric: CPUIntr, Value: percent(cur.irq-prev.irq, total)},
+		gmetric.Sample{Metric: CPUSintr, Value: percent(cur.softirq-prev.softirq, total)},
+		gmetric.Sample{Metric: CPUSteal, Value: percent(cur.steal-prev.steal, total)},
+	), nil
+}
+
+func (c *Collector) loadavg(at time.Time) ([]gmetric.Sample, error) {
+	lines, err := c.readLines("loadavg")
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || len(lines[0]) < 4 {
+		return nil, fmt.Errorf("gmproc: invalid %s", c.path("loadavg"))
+	}
+	f := lines[0]
+
+	var samples []gmetric.Sample
+	for i, m := range []*gmetric.Metric{LoadOne, LoadFive, LoadFifteen} {
+		v, err := strconv.ParseFloat(f[i], 64)
+		if err != nil {
+			return samples, fmt.Errorf("gmproc: invalid %s: %s", c.path("loadavg"), err)
+		}
+		samples = append(samples, gmetric.Sample{Metric: m, Value: v})
+	}
+
+	procs := strings.SplitN(f[3], "/", 2)
+	if len(procs) != 2 {
+		return samples, fmt.Errorf("gmproc: invalid %s", c.path("loadavg"))
+	}
+	for i, m := range []*gmetric.Metric{ProcRun, ProcTotal} {
+		v, err := strconv.ParseUint(procs[i], 10, 32)
+		if err != nil {
+			return samples, fmt.Errorf("gmproc: invalid %s: %s", c.path("loadavg"), err)
+		}
+		// Like gmond, leave out the running process reading the file.
+		if m == ProcRun && v > 0 {
+			v--
+		}
+		samples = append(samples, gmetric.Sample{Metric: m, Value: v})
+	}
+	return samples, nil
+}
+
+var meminfoMetrics = map[string]*gmetric.Metric{
+	"MemTotal:":     MemTotal,
+	"MemFree:":      MemFree,
+	"Shmem:":        MemShared,
+	"Buffers:":      MemBuffers,
+	"Cached:":       MemCached,
+	"SReclaimable:": MemSReclaimable,
+	"SwapFree:":     SwapFree,
+	"SwapTotal:":    SwapTotal,
+}
+
+func (c *Collector) meminfo(at time.Time) ([]gmetric.Sample, error) {
+	lines, err := c.readLines("meminfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []gmetric.Sample
+	for _, f := range lines {
+		if len(f) < 2 {
+			continue
+		}
+		m, ok := meminfoMetrics[f[0]]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			return samples, fmt.Errorf("gmproc: invalid %s: %s", c.path("meminfo"), err)
+		}
+		samples = append(samples, gmetric.Sample{Metric: m, Value: v})
+	}
+	return samples, nil
+}
+
+type netCounters struct {
+	at                                 time.Time
+	bytesIn, pktsIn, bytesOut, pktsOut uint64
+}
+
+func (c *Collector) netdev(at time.Time) ([]gmetric.Sample, error) {
+	lines, err := c.readLines("net/dev")
+	if err != nil {
+		return nil, err
+	}
+
+	cur := &netCounters{at: at}
+	for _, f := range lines {
+		if len(f) == 0 {
+			continue
+		}
+		// The interface name and the first counter are not always separated
+		// by whitespace.
+		i := strings.IndexRune(f[0], ':')
+		if i < 0 {
+			continue
+		}
+		name := f[0][:i]
+		if rest := f[0][i+1:]; rest != "" {
+			f = append([]string{name, rest}, f[1:]...)
+		} else {
+			f[0] = name
+		}
+		if name == "lo" || len(f) < 11 {
+			continue
+		}
+		var v [4]uint64
+		for j, k := range []int{1, 2, 9, 10} {
+			if v[j], err = strconv.ParseUint(f[k], 10, 64); err != nil {
+				return nil, fmt.Errorf("gmproc: invalid %s: %s", c.path("net/dev"), err)
+			}
+		}
+		cur.bytesIn += v[0]
+		cur.pktsIn += v[1]
+		cur.bytesOut += v[2]
+		cur.pktsOut += v[3]
+	}
+
+	prev := c.lastNet
+	c.lastNet = cur
+	if prev == nil {
+		return nil, nil
+	}
+	elapsed := at.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return nil, nil
+	}
+
+	var samples []gmetric.Sample
+	pairs := []struct {
+		metric    *gmetric.Metric
+		cur, prev uint64
+	}{
+		{BytesIn, cur.bytesIn, prev.bytesIn},
+		{BytesOut, cur.bytesOut, prev.bytesOut},
+		{PktsIn, cur.pktsIn, prev.pktsIn},
+		{PktsOut, cur.pktsOut, prev.pktsOut},
+	}
+	for _, p := range pairs {
+		// A counter going backwards means it was reset or wrapped.
+		if p.cur < p.prev {
+			continue
+		}
+		samples = append(samples, gmetric.Sample{
+			Metric: p.metric,
+			Value:  float64(p.cur-p.prev) / elapsed,
+		})
+	}
+	return samples, nil
+}
+
+type diskCounters struct {
+	at                                       time.Time
+	reads, writes, readSectors, writeSectors uint64
+	ioTime                                   uint64
+}
+
+type diskMetrics struct {
+	reads, writes, readBytes, writeBytes, ioTime *gmetric.Metric
+}
+
+func newDiskMetrics(dev string) *diskMetrics {
+	prefix := "diskstat_" + dev + "_"
+	groups := []string{"diskstat"}
+	return &diskMetrics{
+		reads: &gmetric.Metric{
+			Name:        prefix + "reads",
+			Title:       "Reads (" + dev + ")",
+			Description: "The number of reads completed per second",
+			ValueType:   gmetric.ValueFloat32,
+			Units:       "reads/sec",
+			Slope:       gmetric.SlopeBoth,
+			Groups:      groups,
+		},
+		writes: &gmetric.Metric{
+			Name:        prefix + "writes",
+			Title:       "Writes (" + dev + ")",
+			Description: "The number of writes completed per second",
+			ValueType:   gmetric.ValueFloat32,
+			Units:       "writes/sec",
+			Slope:       gmetric.SlopeBoth,
+			Groups:      groups,
+		},
+		readBytes: &gmetric.Metric{
+			Name:        prefix + "read_bytes_per_sec",
+			Title:       "Bytes Read (" + dev + ")",
+			Description: "The number of bytes read per second",
+			ValueType:   gmetric.ValueFloat32,
+			Units:       "bytes/sec",
+			Slope:       gmetric.SlopeBoth,
+			Groups:      groups,
+		},
+		writeBytes: &gmetric.Metric{
+			Name:        prefix + "write_bytes_per_sec",
+			Title:       "Bytes Written (" + dev + ")",
+			Description: "The number of bytes written per second",
+			ValueType:   gmetric.ValueFloat32,
+			Units:       "bytes/sec",
+			Slope:       gmetric.SlopeBoth,
+			Groups:      groups,
+		},
+		ioTime: &gmetric.Metric{
+			Name:        prefix + "percent_io_time",
+			Title:       "I/O Time (" + dev + ")",
+			Description: "The percent of time spent doing I/O",
+			ValueType:   gmetric.ValueFloat32,
+			Units:       "%",
+			Slope:       gmetric.SlopeBoth,
+			Groups:      groups,
+		},
+	}
+}
+
+// The sector size used by /proc/diskstats regardless of the device.
+const sectorSize = 512
+
+func (c *Collector) diskstats(at time.Time) ([]gmetric.Sample, error) {
+	lines, err := c.readLines("diskstats")
+	if err != nil {
+		return nil, err
+	}
+
+	if c.disks == nil {
+		c.disks = make(map[string]*diskMetrics)
+	}
+	cur := make(map[string]diskCounters)
+	var samples []gmetric.Sample
+	for _, f := range lines {
+		if len(f) < 14 {
+			continue
+		}
+		dev := f[2]
+		if strings.HasPrefix(dev, "loop") || strings.HasPrefix(dev, "ram") {
+			continue
+		}
+		var v [5]uint64
+		for i, k := range []int{3, 5, 7, 9, 12} {
+			if v[i], err = strconv.ParseUint(f[k], 10, 64); err != nil {
+				return samples, fmt.Errorf("gmproc: invalid %s: %s", c.path("diskstats"), err)
+			}
+		}
+		d := diskCounters{
+			at:           at,
+			reads:        v[0],
+			readSectors:  v[1],
+			writes:       v[2],
+			writeSectors: v[3],
+			ioTime:       v[4],
+		}
+		cur[dev] = d
+
+		prev, ok := c.lastDisk[dev]
+		if !ok {
+			continue
+		}
+		elapsed := at.Sub(prev.at).Seconds()
+		if elapsed <= 0 || d.reads < prev.reads || d.writes < prev.writes ||
+			d.readSectors < prev.readSectors ||
+			d.writeSectors < prev.writeSectors || d.ioTime < prev.ioTime {
+			continue
+		}
+		m := c.disks[dev]
+		if m == nil {
+			m = newDiskMetrics(dev)
+			c.disks[dev] = m
+		}
+		samples = append(samples,
+			gmetric.Sample{Metric: m.reads, Value: float64(d.reads-prev.reads) / elapsed},
+			gmetric.Sample{Metric: m.writes, Value: float64(d.writes-prev.writes) / elapsed},
+			gmetric.Sample{
+				Metric: m.readBytes,
+				Value:  float64((d.readSectors-prev.readSectors)*sectorSize) / elapsed,
+			},
+			gmetric.Sample{
+				Metric: m.writeBytes,
+				Value:  float64((d.writeSectors-prev.writeSectors)*sectorSize) / elapsed,
+			},
+			gmetric.Sample{
+				// ioTime is in milliseconds.
+				Metric: m.ioTime,
+				Value:  float64(d.ioTime-prev.ioTime) / (elapsed * 10),
+			},
+		)
+	}
+	c.lastDisk = cur
+	return samples, nil
+}
+
+func (c *Collector) mounts(at time.Time) ([]gmetric.Sample, error) {
+	lines, err := c.readLines("mounts")
+	if err != nil {
+		return nil, err
+	}
+
+	statfs := c.Statfs
+	if statfs == nil {
+		statfs = defaultStatfs
+	}
+
+	var total, avail uint64
+	var maxUsed float64
+	seen := make(map[string]bool)
+	for _, f := range lines {
+		if len(f) < 4 {
+			continue
+		}
+		dev, dir, opts := f[0], f[1], strings.Split(f[3], ",")
+		// Same rules as gmond: only real devices, each only once and not
+		// mounted read-only.
+		if !strings.HasPrefix(dev, "/dev/") || strings.HasPrefix(dev, "/dev/loop") {
+			continue
+		}
+		if seen[dev] || (len(opts) > 0 && opts[0] == "ro") {
+			continue
+		}
+		seen[dev] = true
+
+		t, a, err := statfs(dir)
+		if err != nil || t == 0 {
+			continue
+		}
+		total += t
+		avail += a
+		if a <= t {
+			if used := percent(t-a, t); used > maxUsed {
+				maxUsed = used
+			}
+		}
+	}
+
+	return []gmetric.Sample{
+		{Metric: DiskTotal, Value: float64(total) / gigabyte},
+		{Metric: DiskFree, Value: float64(avail) / gigabyte},
+		{Metric: PartMaxUsed, Value: maxUsed},
+	}, nil
+}
+
+func percent(n, total uint64) float64 {
+	return float64(n) * 100 / float64(total)
+}
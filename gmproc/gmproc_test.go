@@ -0,0 +1,138 @@
+package gmproc
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func fakeStatfs(path string) (total, avail uint64, err error) {
+	switch path {
+	case "/":
+		return 100 * gigabyte, 25 * gigabyte, nil
+	case "/data":
+		return 300 * gigabyte, 150 * gigabyte, nil
+	}
+	return 0, 0, fmt.Errorf("unexpected statfs for %s", path)
+}
+
+func collect(t *testing.T, c *Collector, root string) map[string]interface{} {
+	c.Root = root
+	samples, err := c.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	for _, s := range samples {
+		if _, ok := values[s.Metric.Name]; ok {
+			t.Fatalf("duplicate sample for %s", s.Metric.Name)
+		}
+		values[s.Metric.Name] = s.Value
+	}
+	return values
+}
+
+func checkValue(t *testing.T, values map[string]interface{}, name string, expected float64) {
+	actual, ok := values[name]
+	if !ok {
+		t.Fatalf("missing value for %s", name)
+	}
+	var f float64
+	switch v := actual.(type) {
+	case float64:
+		f = v
+	case uint64:
+		f = float64(v)
+	case uint16:
+		f = float64(v)
+	default:
+		t.Fatalf("unexpected type %T for %s", actual, name)
+	}
+	if math.Abs(f-expected) > 1e-9 {
+		t.Fatalf("was expecting %v for %s but got %v", expected, name, f)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+	at := time.Unix(1500000000, 0)
+	c := &Collector{
+		Statfs: fakeStatfs,
+		now:    func() time.Time { return at },
+	}
+
+	first := collect(t, c, "testdata/proc1")
+	checkValue(t, first, "cpu_num", 2)
+	checkValue(t, first, "boottime", 1500000000)
+	checkValue(t, first, "cpu_user", 10)
+	checkValue(t, first, "cpu_idle", 80)
+	checkValue(t, first, "cpu_aidle", 80)
+	checkValue(t, first, "load_one", 0.17)
+	checkValue(t, first, "load_fifteen", 0.06)
+	checkValue(t, first, "proc_run", 1)
+	checkValue(t, first, "proc_total", 71)
+	checkValue(t, first, "mem_total", 6158152)
+	checkValue(t, first, "mem_free", 5134432)
+	checkValue(t, first, "mem_shared", 9484)
+	checkValue(t, first, "mem_buffers", 60992)
+	checkValue(t, first, "mem_cached", 727616)
+	checkValue(t, first, "mem_sreclaimable", 25776)
+	checkValue(t, first, "swap_total", 1048572)
+	checkValue(t, first, "swap_free", 1048000)
+	checkValue(t, first, "disk_total", 400)
+	checkValue(t, first, "disk_free", 175)
+	checkValue(t, first, "part_max_used", 75)
+	for _, name := range []string{"bytes_in", "pkts_out", "diskstat_sda_reads"} {
+		if _, ok := first[name]; ok {
+			t.Fatalf("was not expecting a rate for %s on the first collection", name)
+		}
+	}
+
+	at = at.Add(10 * time.Second)
+	second := collect(t, c, "testdata/proc2")
+	checkValue(t, second, "cpu_user", 25)
+	checkValue(t, second, "cpu_nice", 0)
+	checkValue(t, second, "cpu_system", 10)
+	checkValue(t, second, "cpu_idle", 50)
+	checkValue(t, second, "cpu_wio", 5)
+	checkValue(t, second, "cpu_steal", 10)
+	checkValue(t, second, "cpu_aidle", 75)
+	checkValue(t, second, "bytes_in", 4000)
+	checkValue(t, second, "pkts_in", 40)
+	checkValue(t, second, "bytes_out", 1000)
+	checkValue(t, second, "pkts_out", 10)
+	checkValue(t, second, "diskstat_sda_reads", 10)
+	checkValue(t, second, "diskstat_sda_writes", 40)
+	checkValue(t, second, "diskstat_sda_read_bytes_per_sec", 102400)
+	checkValue(t, second, "diskstat_sda_write_bytes_per_sec", 204800)
+	checkValue(t, second, "diskstat_sda_percent_io_time", 50)
+	checkValue(t, second, "diskstat_sda1_reads", 10)
+	if _, ok := second["diskstat_loop0_reads"]; ok {
+		t.Fatal("was not expecting loop devices")
+	}
+}
+
+func TestCollectMissingRoot(t *testing.T) {
+	t.Parallel()
+	c := &Collector{Root: "testdata/does-not-exist"}
+	samples, err := c.Collect()
+	if err == nil {
+		t.Fatal("was expecting an error")
+	}
+	if len(samples) != 0 {
+		t.Fatalf("was expecting no samples but got %d", len(samples))
+	}
+}
+
+func TestMetricsHaveUniqueNames(t *testing.T) {
+	t.Parallel()
+	c := &Collector{}
+	seen := make(map[string]bool)
+	for _, m := range c.Metrics() {
+		if seen[m.Name] {
+			t.Fatalf("duplicate metric %s", m.Name)
+		}
+		seen[m.Name] = true
+	}
+}
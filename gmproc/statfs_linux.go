@@ -0,0 +1,11 @@
+package gmproc
+
+import "syscall"
+
+func defaultStatfs(path string) (total, avail uint64, err error) {
+	var s syscall.Statfs_t
+	if err := syscall.Statfs(path, &s); err != nil {
+		return 0, 0, err
+	}
+	return s.Blocks * uint64(s.Bsize), s.Bavail * uint64(s.Bsize), nil
+}
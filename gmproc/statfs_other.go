@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package gmproc
+
+import "errors"
+
+var errStatfsUnsupported = errors.New("gmproc: statfs is only supported on linux")
+
+func defaultStatfs(path string) (total, avail uint64, err error) {
+	return 0, 0, errStatfsUnsupported
+}
@@ -0,0 +1,527 @@
+// Package gmprom scrapes the Prometheus text exposition format and publishes
+// the metrics to ganglia.
+package gmprom
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// The default quantiles derived from histograms.
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// The default limit on the number of series published per scrape.
+const defaultMaxSeries = 1000
+
+// The default number of scrapes a series may be missing from.
+const defaultIdleScrapes = 10
+
+// Action defines how a label is mapped onto ganglia.
+type Action int
+
+const (
+	// Suffix appends ".<name>.<value>" to the metric name, with the label
+	// name and value escaped by gmetric.EscapeLabel so distinct labels always
+	// give distinct names.
+	Suffix Action = iota
+
+	// Group appends ".<name>.<value>" to the metric name like Suffix, and
+	// also adds the value as a group of the metric. The value stays in the name as
+	// ganglia metric names must be unique regardless of their groups.
+	Group
+
+	// Drop ignores the label, series only differing by it are summed.
+	Drop
+)
+
+// LabelRule maps a label onto ganglia.
+type LabelRule struct {
+	// Metric is a path.Match pattern for the names of the metric families the
+	// rule applies to. Empty matches all.
+	Metric string
+
+	// The label name.
+	Label string
+
+	// How the label is mapped.
+	Action Action
+}
+
+// Bridge is a gmetric.Collector that scrapes a Prometheus endpoint or file in
+// the text exposition format. Gauges and untyped metrics are published as
+// is, counters as rates per second. Summary quantiles are published as
+// "<name>_p50" and so on, and the quantiles of histograms are derived from
+// the buckets observed since the previous scrape. Histograms and summaries
+// also publish "<name>_rate", the observations per second, and "<name>_avg",
+// the average observation since the previous scrape. Rates are omitted on
+// the first scrape. Labels without a rule are appended to the name like the
+// Suffix action, in the order of their names, so the labels code="200" and
+// method="get" on "requests" give "requests.code.200.method.get".
+type Bridge struct {
+	// The URL to scrape, or the path to a file.
+	Source string
+
+	// Optional client used for URLs. Defaults to one with a 10 second
+	// timeout.
+	HTTPClient *http.Client
+
+	// Prefix for the metric names.
+	Prefix string
+
+	// The default groups for the metrics. If any label maps to a group, only
+	// the label groups are used.
+	Groups []string
+
+	// The rules are checked in order and the first matching one applies.
+	Labels []LabelRule
+
+	// The quantiles derived from histograms. Defaults to 0.5, 0.9 and 0.99.
+	Quantiles []float64
+
+	// The maximum number of series published per scrape. The series over the
+	// limit are dropped and counted in "<prefix>prom_dropped_series".
+	// Defaults to 1000.
+	MaxSeries int
+
+	// Series missing from this many consecutive scrapes are no longer
+	// published and forgotten, so series removed from the source do not
+	// accumulate. Defaults to 10, a negative value keeps series forever.
+	IdleScrapes int
+
+	mu       sync.Mutex
+	now      func() time.Time
+	scrapes  uint64
+	metrics  map[string]*gmetric.Metric
+	seen     map[string]uint64
+	counters map[string]counterState
+	dists    map[string]distState
+	dropped  *gmetric.Metric
+}
+
+var _ gmetric.Collector = (*Bridge)(nil)
+
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+type counterState struct {
+	value  float64
+	at     time.Time
+	scrape uint64
+}
+
+type distState struct {
+	buckets    map[float64]float64
+	sum, count float64
+	at         time.Time
+	scrape     uint64
+}
+
+// A series is the aggregate of the samples mapped onto the same metric name.
+type series struct {
+	name      string
+	family    *family
+	groups    []string
+	value     float64
+	quantiles map[float64]float64
+	buckets   map[float64]float64
+	sum       float64
+	count     float64
+}
+
+// Metrics returns the metrics published so far.
+func (b *Bridge) Metrics() []*gmetric.Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	names := make([]string, 0, len(b.metrics))
+	for name := range b.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := []*gmetric.Metric{b.dropped}
+	for _, name := range names {
+		metrics = append(metrics, b.metrics[name])
+	}
+	return metrics
+}
+
+func (b *Bridge) init() {
+	if b.dropped != nil {
+		return
+	}
+	b.metrics = make(map[string]*gmetric.Metric)
+	b.seen = make(map[string]uint64)
+	b.counters = make(map[string]counterState)
+	b.dists = make(map[string]distState)
+	b.dropped = &gmetric.Metric{
+		Name:        b.Prefix + "prom_dropped_series",
+		Title:       "Dropped Prometheus Series",
+		Description: "The number of series dropped by the cardinality limit",
+		Units:       "series",
+		Groups:      b.Groups,
+		ValueType:   gmetric.ValueUint32,
+		Slope:       gmetric.SlopeBoth,
+	}
+}
+
+// Collect scrapes the source and returns the samples.
+func (b *Bridge) Collect() ([]gmetric.Sample, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+
+	families, err := b.scrape()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+	at := now()
+	b.scrapes++
+
+	all := b.series(families)
+	limit := b.MaxSeries
+	if limit <= 0 {
+		limit = defaultMaxSeries
+	}
+	dropped := 0
+	if len(all) > limit {
+		dropped = len(all) - limit
+		all = all[:limit]
+	}
+
+	samples := []gmetric.Sample{{Metric: b.dropped, Value: dropped}}
+	for _, s := range all {
+		samples = append(samples, b.samples(s, at)...)
+	}
+	b.expire()
+	if dropped > 0 {
+		return samples, fmt.Errorf("gmprom: dropped %d series over the limit of %d", dropped, limit)
+	}
+	return samples, nil
+}
+
+func (b *Bridge) scrape() ([]*family, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(b.Source, "http://") || strings.HasPrefix(b.Source, "https://") {
+		client := b.HTTPClient
+		if client == nil {
+			client = defaultHTTPClient
+		}
+		res, err := client.Get(b.Source)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("gmprom: unexpected status %s from %s", res.Status, b.Source)
+		}
+		r = res.Body
+	} else {
+		f, err := os.Open(b.Source)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	return parse(r)
+}
+
+// Maps the samples onto series, sorted by name.
+func (b *Bridge) series(families []*family) []*series {
+	byName := make(map[string]*series)
+	for _, f := range families {
+		for _, smp := range f.samples {
+			name, groups, le, quantile := b.mapLabels(f, smp)
+			s := byName[name]
+			if s == nil {
+				s = &series{name: name, family: f, groups: groups}
+				byName[name] = s
+			}
+			switch {
+			case f.typ == typeHistogram && smp.name == f.name+"_bucket":
+				if le != nil {
+					if s.buckets == nil {
+						s.buckets = make(map[float64]float64)
+					}
+					s.buckets[*le] += smp.value
+				}
+			case f.typ == typeSummary && smp.name == f.name:
+				if quantile != nil {
+					if s.quantiles == nil {
+						s.quantiles = make(map[float64]float64)
+					}
+					// Quantiles cannot be aggregated, keep the largest.
+					if cur, ok := s.quantiles[*quantile]; !ok || smp.value > cur {
+						s.quantiles[*quantile] = smp.value
+					}
+				}
+			case (f.typ == typeHistogram || f.typ == typeSummary) && smp.name == f.name+"_sum":
+				s.sum += smp.value
+			case (f.typ == typeHistogram || f.typ == typeSummary) && smp.name == f.name+"_count":
+				s.count += smp.value
+			default:
+				s.value += smp.value
+			}
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := make([]*series, 0, len(names))
+	for _, name := range names {
+		all = append(all, byName[name])
+	}
+	return all
+}
+
+// Returns the series name and groups for the sample, along with the parsed
+// le and quantile labels if present.
+func (b *Bridge) mapLabels(f *family, smp sample) (string, []string, *float64, *float64) {
+	labels := append([]label(nil), smp.labels...)
+	sort.Sort(byLabelName(labels))
+
+	name := b.Prefix + f.name
+	var groups []string
+	var le, quantile *float64
+	for _, l := range labels {
+		if f.typ == typeHistogram && l.name == "le" {
+			if v, err := parseValue(l.value); err == nil {
+				le = &v
+			}
+			continue
+		}
+		if f.typ == typeSummary && l.name == "quantile" {
+			if v, err := parseValue(l.value); err == nil {
+				quantile = &v
+			}
+			continue
+		}
+		action := b.action(f.name, l.name)
+		if action == Drop {
+			continue
+		}
+		name += "." + gmetric.EscapeLabel(l.name) + "." + gmetric.EscapeLabel(l.value)
+		if action == Group {
+			groups = append(groups, l.value)
+		}
+	}
+	if len(groups) == 0 {
+		groups = b.Groups
+	}
+	return name, groups, le, quantile
+}
+
+func (b *Bridge) action(family, label string) Action {
+	for _, r := range b.Labels {
+		if r.Label != label {
+			continue
+		}
+		if r.Metric == "" {
+			return r.Action
+		}
+		if matched, _ := path.Match(r.Metric, family); matched {
+			return r.Action
+		}
+	}
+	return Suffix
+}
+
+func (b *Bridge) samples(s *series, at time.Time) []gmetric.Sample {
+	switch s.family.typ {
+	case typeCounter:
+		last, ok := b.counters[s.name]
+		b.counters[s.name] = counterState{value: s.value, at: at, scrape: b.scrapes}
+		elapsed := at.Sub(last.at).Seconds()
+		if !ok || elapsed <= 0 || s.value < last.value {
+			return nil
+		}
+		return []gmetric.Sample{{
+			Metric: b.metric(s.name, s.family, s.groups),
+			Value:  (s.value - last.value) / elapsed,
+		}}
+	case typeHistogram, typeSummary:
+		return b.distSamples(s, at)
+	}
+	if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+		return nil
+	}
+	return []gmetric.Sample{{
+		Metric: b.metric(s.name, s.family, s.groups),
+		Value:  s.value,
+	}}
+}
+
+func (b *Bridge) distSamples(s *series, at time.Time) []gmetric.Sample {
+	var samples []gmetric.Sample
+	for _, q := range sortedKeys(s.quantiles) {
+		v := s.quantiles[q]
+		if math.IsNaN(v) {
+			continue
+		}
+		samples = append(samples, gmetric.Sample{
+			Metric: b.metric(s.name+"_"+gmetric.PercentileSuffix(q*100), s.family, s.groups),
+			Value:  v,
+		})
+	}
+
+	last, ok := b.dists[s.name]
+	b.dists[s.name] = distState{
+		buckets: s.buckets,
+		sum:     s.sum,
+		count:   s.count,
+		at:      at,
+		scrape:  b.scrapes,
+	}
+	elapsed := at.Sub(last.at).Seconds()
+	if !ok || elapsed <= 0 || s.count < last.count {
+		return samples
+	}
+
+	count := s.count - last.count
+	samples = append(samples, gmetric.Sample{
+		Metric: b.metric(s.name+"_rate", s.family, s.groups),
+		Value:  count / elapsed,
+	})
+	if count > 0 {
+		samples = append(samples, gmetric.Sample{
+			Metric: b.metric(s.name+"_avg", s.family, s.groups),
+			Value:  (s.sum - last.sum) / count,
+		})
+	}
+
+	if s.family.typ != typeHistogram || count == 0 {
+		return samples
+	}
+	deltas := make(map[float64]float64, len(s.buckets))
+	for le, v := range s.buckets {
+		deltas[le] = v - last.buckets[le]
+	}
+	quantiles := b.Quantiles
+	if len(quantiles) == 0 {
+		quantiles = defaultQuantiles
+	}
+	for _, q := range quantiles {
+		v, ok := bucketQuantile(q, deltas)
+		if !ok {
+			continue
+		}
+		samples = append(samples, gmetric.Sample{
+			Metric: b.metric(s.name+"_"+gmetric.PercentileSuffix(q*100), s.family, s.groups),
+			Value:  v,
+		})
+	}
+	return samples
+}
+
+func (b *Bridge) metric(name string, f *family, groups []string) *gmetric.Metric {
+	b.seen[name] = b.scrapes
+	m := b.metrics[name]
+	if m != nil && m.HasGroups(groups) {
+		return m
+	}
+	m = &gmetric.Metric{
+		Name:        name,
+		Description: f.help,
+		Groups:      groups,
+		ValueType:   gmetric.ValueFloat64,
+		Slope:       gmetric.SlopeBoth,
+	}
+	b.metrics[name] = m
+	return m
+}
+
+// Forgets the metrics and series missing from the last IdleScrapes scrapes.
+func (b *Bridge) expire() {
+	idle := b.IdleScrapes
+	if idle == 0 {
+		idle = defaultIdleScrapes
+	}
+	if idle < 0 {
+		return
+	}
+	for name, scrape := range b.seen {
+		if b.scrapes-scrape >= uint64(idle) {
+			delete(b.seen, name)
+			delete(b.metrics, name)
+		}
+	}
+	for name, c := range b.counters {
+		if b.scrapes-c.scrape >= uint64(idle) {
+			delete(b.counters, name)
+		}
+	}
+	for name, d := range b.dists {
+		if b.scrapes-d.scrape >= uint64(idle) {
+			delete(b.dists, name)
+		}
+	}
+}
+
+// Estimates the quantile from the cumulative bucket counts using linear
+// interpolation within the bucket, the same as histogram_quantile.
+func bucketQuantile(q float64, buckets map[float64]float64) (float64, bool) {
+	bounds := sortedKeys(buckets)
+	if len(bounds) == 0 || !math.IsInf(bounds[len(bounds)-1], 1) {
+		return 0, false
+	}
+	total := buckets[bounds[len(bounds)-1]]
+	if total <= 0 {
+		return 0, false
+	}
+
+	rank := q * total
+	var lower, lowerCount float64
+	for i, upper := range bounds {
+		count := buckets[upper]
+		if count < rank {
+			lower, lowerCount = upper, count
+			continue
+		}
+		if math.IsInf(upper, 1) {
+			// The highest finite bound is the best estimate.
+			if i == 0 {
+				return 0, false
+			}
+			return bounds[i-1], true
+		}
+		if i == 0 && upper <= 0 {
+			return upper, true
+		}
+		if count == lowerCount {
+			return upper, true
+		}
+		return lower + (upper-lower)*(rank-lowerCount)/(count-lowerCount), true
+	}
+	return 0, false
+}
+
+func sortedKeys(m map[float64]float64) []float64 {
+	keys := make([]float64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Float64s(keys)
+	return keys
+}
+
+type byLabelName []label
+
+func (l byLabelName) Len() int           { return len(l) }
+func (l byLabelName) Less(i, j int) bool { return l[i].name < l[j].name }
+func (l byLabelName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,378 @@
+package gmprom
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const scrape1 = `# HELP http_requests_total The total number of requests.
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} 100
+http_requests_total{method="post",code="200"} 10
+# TYPE temperature gauge
+temperature{room="a b"} 21.5
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.2
+rpc_duration_seconds{quantile="0.99"} 1.5
+rpc_duration_seconds_sum 20
+rpc_duration_seconds_count 100
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 10
+latency_seconds_bucket{le="1"} 20
+latency_seconds_bucket{le="+Inf"} 20
+latency_seconds_sum 5
+latency_seconds_count 20
+untyped_thing 7
+`
+
+const scrape2 = `# HELP http_requests_total The total number of requests.
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} 150
+http_requests_total{method="post",code="200"} 30
+# TYPE temperature gauge
+temperature{room="a b"} 22
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.3
+rpc_duration_seconds{quantile="0.99"} 1.7
+rpc_duration_seconds_sum 50
+rpc_duration_seconds_count 200
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 20
+latency_seconds_bucket{le="1"} 30
+latency_seconds_bucket{le="+Inf"} 40
+latency_seconds_sum 15
+latency_seconds_count 40
+untyped_thing 8
+`
+
+func writeFile(t *testing.T, dir, content string) string {
+	name := filepath.Join(dir, "metrics.prom")
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func collect(t *testing.T, b *Bridge) map[string]interface{} {
+	samples, err := b.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	for _, s := range samples {
+		if _, ok := values[s.Metric.Name]; ok {
+			t.Fatalf("duplicate sample for %s", s.Metric.Name)
+		}
+		values[s.Metric.Name] = s.Value
+	}
+	return values
+}
+
+func checkValue(t *testing.T, values map[string]interface{}, name string, expected float64) {
+	actual, ok := values[name]
+	if !ok {
+		t.Fatalf("missing value for %s", name)
+	}
+	f, ok := actual.(float64)
+	if !ok {
+		t.Fatalf("unexpected type %T for %s", actual, name)
+	}
+	if math.Abs(f-expected) > 1e-9 {
+		t.Fatalf("was expecting %v for %s but got %v", expected, name, f)
+	}
+}
+
+func checkMissing(t *testing.T, values map[string]interface{}, name string) {
+	if v, ok := values[name]; ok {
+		t.Fatalf("was not expecting a value for %s but got %v", name, v)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gmprom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	at := time.Unix(1500000000, 0)
+	b := &Bridge{
+		Source: writeFile(t, dir, scrape1),
+		Prefix: "app_",
+		now:    func() time.Time { return at },
+	}
+
+	first := collect(t, b)
+	checkValue(t, first, "app_temperature.room.a%20b", 21.5)
+	checkValue(t, first, "app_untyped_thing", 7)
+	checkValue(t, first, "app_rpc_duration_seconds_p50", 0.2)
+	checkValue(t, first, "app_rpc_duration_seconds_p99", 1.5)
+	checkMissing(t, first, "app_http_requests_total.code.200.method.get")
+	checkMissing(t, first, "app_rpc_duration_seconds_rate")
+	checkMissing(t, first, "app_latency_seconds_p50")
+	if first["app_prom_dropped_series"] != 0 {
+		t.Fatalf("was expecting no dropped series but got %v", first["app_prom_dropped_series"])
+	}
+
+	at = at.Add(10 * time.Second)
+	writeFile(t, dir, scrape2)
+	second := collect(t, b)
+	checkValue(t, second, "app_http_requests_total.code.200.method.get", 5)
+	checkValue(t, second, "app_http_requests_total.code.200.method.post", 2)
+	checkValue(t, second, "app_temperature.room.a%20b", 22)
+	checkValue(t, second, "app_rpc_duration_seconds_rate", 10)
+	checkValue(t, second, "app_rpc_duration_seconds_avg", 0.3)
+	checkValue(t, second, "app_latency_seconds_rate", 2)
+	checkValue(t, second, "app_latency_seconds_avg", 0.5)
+	// 20 new observations: 10 up to 0.1, 0 up to 1 and 10 above.
+	checkValue(t, second, "app_latency_seconds_p50", 0.1)
+	checkValue(t, second, "app_latency_seconds_p90", 1)
+
+	for _, m := range b.Metrics() {
+		if m.Name == "app_http_requests_total.code.200.method.get" {
+			if m.Description != "The total number of requests." {
+				t.Fatalf("unexpected description %q", m.Description)
+			}
+			return
+		}
+	}
+	t.Fatal("missing metric for app_http_requests_total.code.200.method.get")
+}
+
+func TestBridgeCounterReset(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gmprom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	at := time.Unix(1500000000, 0)
+	b := &Bridge{
+		Source: writeFile(t, dir, "# TYPE c counter\nc 100\n"),
+		now:    func() time.Time { return at },
+	}
+	collect(t, b)
+	at = at.Add(time.Second)
+	writeFile(t, dir, "# TYPE c counter\nc 5\n")
+	checkMissing(t, collect(t, b), "c")
+	at = at.Add(time.Second)
+	writeFile(t, dir, "# TYPE c counter\nc 8\n")
+	checkValue(t, collect(t, b), "c", 3)
+}
+
+func TestBridgeLabelRules(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gmprom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &Bridge{
+		Source: writeFile(t, dir, `queue_depth{queue="a",shard="1",host="x"} 1
+queue_depth{queue="a",shard="2",host="x"} 2
+queue_depth{queue="b",shard="1",host="x"} 4
+queue_depth{queue="a",shard="1",host="y"} 8
+`),
+		Groups: []string{"default"},
+		Labels: []LabelRule{
+			{Metric: "queue_*", Label: "shard", Action: Drop},
+			{Label: "host", Action: Group},
+		},
+	}
+	samples, err := b.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, s := range samples {
+		var group string
+		switch s.Metric.Name {
+		case "queue_depth.host.x.queue.a", "queue_depth.host.x.queue.b":
+			group = "x"
+		case "queue_depth.host.y.queue.a":
+			group = "y"
+		default:
+			continue
+		}
+		found++
+		if len(s.Metric.Groups) != 1 || s.Metric.Groups[0] != group {
+			t.Fatalf("was expecting group %s but got %v", group, s.Metric.Groups)
+		}
+	}
+	if found != 3 {
+		t.Fatalf("was expecting 3 series but got %d", found)
+	}
+	values := collect(t, b)
+	checkValue(t, values, "queue_depth.host.x.queue.a", 3)
+	checkValue(t, values, "queue_depth.host.x.queue.b", 4)
+	checkValue(t, values, "queue_depth.host.y.queue.a", 8)
+}
+
+func TestBridgeLabelNames(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gmprom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &Bridge{Source: writeFile(t, dir, `g{room="a b"} 1
+g{room="a_b"} 2
+g{room="a.b"} 3
+h{code="get"} 4
+h{method="get"} 5
+`)}
+	values := collect(t, b)
+	checkValue(t, values, "g.room.a%20b", 1)
+	checkValue(t, values, "g.room.a_b", 2)
+	checkValue(t, values, "g.room.a%2Eb", 3)
+	checkValue(t, values, "h.code.get", 4)
+	checkValue(t, values, "h.method.get", 5)
+}
+
+func TestBridgeMaxSeries(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gmprom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var lines []string
+	for i := 0; i < 5; i++ {
+		lines = append(lines, fmt.Sprintf("g{id=\"%d\"} %d", i, i))
+	}
+	b := &Bridge{
+		Source:    writeFile(t, dir, strings.Join(lines, "\n")),
+		MaxSeries: 3,
+	}
+	samples, err := b.Collect()
+	if err == nil || !strings.Contains(err.Error(), "dropped 2 series") {
+		t.Fatalf("was expecting a dropped series error but got %v", err)
+	}
+	values := make(map[string]interface{})
+	for _, s := range samples {
+		values[s.Metric.Name] = s.Value
+	}
+	if values["prom_dropped_series"] != 2 {
+		t.Fatalf("was expecting 2 dropped series but got %v", values["prom_dropped_series"])
+	}
+	checkValue(t, values, "g.id.2", 2)
+	checkMissing(t, values, "g.id.3")
+}
+
+func TestBridgeIdleScrapes(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gmprom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	at := time.Unix(1500000000, 0)
+	b := &Bridge{
+		Source:      writeFile(t, dir, "# TYPE c counter\nc 1\ng 1\n"),
+		IdleScrapes: 2,
+		now:         func() time.Time { return at },
+	}
+	collect(t, b)
+	at = at.Add(time.Second)
+	writeFile(t, dir, "# TYPE c counter\nc 2\ng 1\n")
+	checkValue(t, collect(t, b), "c", 1)
+
+	writeFile(t, dir, "other 1\n")
+	for i := 0; i < 2; i++ {
+		at = at.Add(time.Second)
+		values := collect(t, b)
+		checkMissing(t, values, "c")
+		checkMissing(t, values, "g")
+	}
+	for _, m := range b.Metrics() {
+		if m.Name == "c" || m.Name == "g" {
+			t.Fatalf("was expecting %s to be forgotten", m.Name)
+		}
+	}
+	if len(b.counters) != 0 {
+		t.Fatalf("was expecting no counters but got %v", b.counters)
+	}
+
+	// The counter starts over, without a rate for its first scrape.
+	at = at.Add(time.Second)
+	writeFile(t, dir, "# TYPE c counter\nc 10\n")
+	checkMissing(t, collect(t, b), "c")
+}
+
+func TestBridgeHTTP(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, scrape1)
+	}))
+	defer server.Close()
+
+	b := &Bridge{Source: server.URL + "/metrics"}
+	checkValue(t, collect(t, b), "temperature.room.a%20b", 21.5)
+
+	b = &Bridge{Source: server.URL + "/missing"}
+	if _, err := b.Collect(); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("was expecting a status error but got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	families, err := parse(strings.NewReader(`# HELP a Some help.
+# TYPE a gauge
+a{x="q\"uote",y="new\nline"} +Inf 1500000000000
+b -1.5e3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 2 {
+		t.Fatalf("was expecting 2 families but got %d", len(families))
+	}
+	a := families[0]
+	if a.typ != typeGauge || a.help != "Some help." {
+		t.Fatalf("unexpected family %+v", a)
+	}
+	smp := a.samples[0]
+	if !math.IsInf(smp.value, 1) {
+		t.Fatalf("was expecting +Inf but got %v", smp.value)
+	}
+	if smp.labels[0].value != `q"uote` || smp.labels[1].value != "new\nline" {
+		t.Fatalf("unexpected labels %+v", smp.labels)
+	}
+	if families[1].samples[0].value != -1500 {
+		t.Fatalf("was expecting -1500 but got %v", families[1].samples[0].value)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	cases := []string{
+		"a{x=\"1\" 1\n",
+		"a notanumber\n",
+		"{x=\"1\"} 1\n",
+		"a 1 2 3\n",
+	}
+	for _, c := range cases {
+		if _, err := parse(strings.NewReader(c)); err == nil {
+			t.Fatalf("was expecting an error for %q", c)
+		}
+	}
+}
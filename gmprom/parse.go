@@ -0,0 +1,187 @@
+package gmprom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The metric types of the Prometheus text exposition format.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+	typeSummary   = "summary"
+	typeUntyped   = "untyped"
+)
+
+type label struct {
+	name, value string
+}
+
+type sample struct {
+	name   string
+	labels []label
+	value  float64
+}
+
+type family struct {
+	name    string
+	typ     string
+	help    string
+	samples []sample
+}
+
+// Parses the Prometheus text exposition format. The families are returned in
+// the order they first appear.
+func parse(r io.Reader) ([]*family, error) {
+	var families []*family
+	byName := make(map[string]*family)
+	get := func(name string) *family {
+		f := byName[name]
+		if f == nil {
+			f = &family{name: name, typ: typeUntyped}
+			byName[name] = f
+			families = append(families, f)
+		}
+		return f
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			f := strings.Fields(line)
+			if len(f) < 3 || (f[1] != "HELP" && f[1] != "TYPE") {
+				continue
+			}
+			fam := get(f[2])
+			if f[1] == "TYPE" && len(f) > 3 {
+				fam.typ = f[3]
+			} else if f[1] == "HELP" {
+				fam.help = strings.TrimSpace(strings.SplitN(line, f[2], 2)[1])
+			}
+			continue
+		}
+
+		smp, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("gmprom: line %d: %s", n, err)
+		}
+		fam := get(familyName(smp.name, byName))
+		fam.samples = append(fam.samples, smp)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return families, nil
+}
+
+// Returns the name of the family a sample belongs to, accounting for the
+// suffixes used by histograms and summaries.
+func familyName(name string, families map[string]*family) string {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		base := strings.TrimSuffix(name, suffix)
+		if f, ok := families[base]; ok && (f.typ == typeHistogram || f.typ == typeSummary) {
+			if suffix != "_bucket" || f.typ == typeHistogram {
+				return base
+			}
+		}
+	}
+	return name
+}
+
+func parseSample(line string) (sample, error) {
+	var smp sample
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return smp, fmt.Errorf("invalid sample %q", line)
+	}
+	smp.name = line[:end]
+	line = line[end:]
+
+	if line[0] == '{' {
+		var err error
+		if smp.labels, line, err = parseLabels(line[1:]); err != nil {
+			return smp, err
+		}
+	}
+
+	f := strings.Fields(line)
+	if len(f) == 0 || len(f) > 2 {
+		return smp, fmt.Errorf("invalid value for %s", smp.name)
+	}
+	v, err := parseValue(f[0])
+	if err != nil {
+		return smp, fmt.Errorf("invalid value for %s: %s", smp.name, err)
+	}
+	smp.value = v
+	return smp, nil
+}
+
+// Parses the labels following the opening brace, returning the rest of the
+// line after the closing brace.
+func parseLabels(line string) ([]label, string, error) {
+	var labels []label
+	for {
+		line = strings.TrimLeft(line, " \t,")
+		if line == "" {
+			return nil, "", fmt.Errorf("unclosed labels")
+		}
+		if line[0] == '}' {
+			return labels, line[1:], nil
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, "", fmt.Errorf("invalid label %q", line)
+		}
+		name := strings.TrimSpace(line[:eq])
+		line = strings.TrimLeft(line[eq+1:], " \t")
+		if line == "" || line[0] != '"' {
+			return nil, "", fmt.Errorf("unquoted value for label %s", name)
+		}
+
+		var value []byte
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+				switch line[i] {
+				case 'n':
+					value = append(value, '\n')
+				default:
+					value = append(value, line[i])
+				}
+				continue
+			}
+			value = append(value, line[i])
+		}
+		if i >= len(line) {
+			return nil, "", fmt.Errorf("unclosed value for label %s", name)
+		}
+		labels = append(labels, label{name: name, value: string(value)})
+		line = line[i+1:]
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,312 @@
+// Package gmscript runs scripts and publishes the metrics they print to
+// ganglia, replacing scripts that call the gmetric CLI once per value.
+//
+// Scripts print one metric per line:
+//
+//	<name> <value> <type> <units> [<group>]
+//
+// The type is one of string, int8, uint8, int16, uint16, int32, uint32, float
+// or double, and integer values must be whole numbers in the range of the
+// type. Units of "-" mean there are none. Fields containing whitespace
+// may be double quoted using Go syntax. Blank lines and lines starting with
+// "#" are ignored.
+package gmscript
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+var (
+	errNoCommand     = errors.New("gmscript: script has no command")
+	errUnclosedQuote = errors.New("unclosed quote")
+	errTooFewFields  = errors.New("too few fields")
+	errTooManyFields = errors.New("too many fields")
+	errUnknownType   = errors.New("unknown type")
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultPrefix  = "script"
+)
+
+// Script is a gmetric.Collector that runs a command on every Collect and
+// provides the metrics it prints. Along with them it provides metrics about
+// the script itself, "<prefix>_<name>_exit_code",
+// "<prefix>_<name>_malformed_lines" and "<prefix>_<name>_duration", so
+// failing scripts show up in ganglia too. Use a Scheduler to run it on an
+// interval.
+type Script struct {
+	// The name of the script, used for the metrics about the script. It
+	// should only contain characters safe for a file name.
+	Name string
+
+	// The command and its arguments.
+	Command []string
+
+	// The command is killed if it runs for longer. Defaults to 30 seconds.
+	// Note processes started by the command are not killed, and if they keep
+	// its output open Collect waits for them.
+	Timeout time.Duration
+
+	// The groups for the metrics printed without a group.
+	Groups []string
+
+	// Prefix for the metrics about the script. Defaults to "script".
+	Prefix string
+
+	mu       sync.Mutex
+	metrics  map[string]*gmetric.Metric
+	exitCode *gmetric.Metric
+	invalid  *gmetric.Metric
+	duration *gmetric.Metric
+}
+
+var _ gmetric.Collector = (*Script)(nil)
+
+// Metrics returns the metrics about the script. The metrics printed by the
+// script are discovered by Collect.
+func (s *Script) Metrics() []*gmetric.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	return []*gmetric.Metric{s.exitCode, s.invalid, s.duration}
+}
+
+func (s *Script) init() {
+	if s.exitCode != nil {
+		return
+	}
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	base := prefix + "_" + s.Name
+	groups := []string{prefix}
+	s.exitCode = &gmetric.Metric{
+		Name:        base + "_exit_code",
+		Title:       "Exit Code (" + s.Name + ")",
+		Description: "The exit code of the script, -1 if it could not be run or timed out",
+		Groups:      groups,
+		ValueType:   gmetric.ValueInt32,
+		Slope:       gmetric.SlopeBoth,
+	}
+	s.invalid = &gmetric.Metric{
+		Name:        base + "_malformed_lines",
+		Title:       "Malformed Lines (" + s.Name + ")",
+		Description: "The number of lines printed by the script that could not be parsed",
+		Groups:      groups,
+		Units:       "lines",
+		ValueType:   gmetric.ValueUint32,
+		Slope:       gmetric.SlopeBoth,
+	}
+	s.duration = &gmetric.Metric{
+		Name:        base + "_duration",
+		Title:       "Duration (" + s.Name + ")",
+		Description: "The time the script took to run",
+		Groups:      groups,
+		Units:       "seconds",
+		ValueType:   gmetric.ValueFloat64,
+		Slope:       gmetric.SlopeBoth,
+	}
+}
+
+// Collect runs the command and returns the metrics it printed. Lines that
+// could not be parsed and a failed command are reported in the returned
+// MultiError as well as in the metrics about the script. Output is read up to
+// the first line longer than 64KB, which is reported in the MultiError.
+func (s *Script) Collect() ([]gmetric.Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if len(s.Command) == 0 {
+		return nil, errNoCommand
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Stdout = &stdout
+	start := time.Now()
+	runErr := cmd.Run()
+	took := time.Since(start)
+
+	var errs gmetric.MultiError
+	exitCode := 0
+	if runErr != nil {
+		exitCode = -1
+		if exit, ok := runErr.(*exec.ExitError); ok && ctx.Err() == nil {
+			exitCode = exit.ExitCode()
+		}
+		if ctx.Err() != nil {
+			runErr = fmt.Errorf("gmscript: %s timed out after %s", s.Name, timeout)
+		} else {
+			runErr = fmt.Errorf("gmscript: %s failed: %s", s.Name, runErr)
+		}
+		errs = append(errs, runErr)
+	}
+
+	var samples []gmetric.Sample
+	var malformed int
+	scanner := bufio.NewScanner(&stdout)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample, err := s.parse(line)
+		if err != nil {
+			malformed++
+			errs = append(errs, fmt.Errorf("gmscript: %s line %d: %s", s.Name, n, err))
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("gmscript: %s output: %s", s.Name, err))
+	}
+
+	samples = append(samples,
+		gmetric.Sample{Metric: s.exitCode, Value: exitCode},
+		gmetric.Sample{Metric: s.invalid, Value: malformed},
+		gmetric.Sample{Metric: s.duration, Value: took.Seconds()},
+	)
+	if len(errs) == 0 {
+		return samples, nil
+	}
+	return samples, errs
+}
+
+func (s *Script) parse(line string) (gmetric.Sample, error) {
+	fields, err := split(line)
+	if err != nil {
+		return gmetric.Sample{}, err
+	}
+	if len(fields) < 4 {
+		return gmetric.Sample{}, errTooFewFields
+	}
+	if len(fields) > 5 {
+		return gmetric.Sample{}, errTooManyFields
+	}
+	name, value, typ, units := fields[0], fields[1], fields[2], fields[3]
+	typed, err := typedMetric(typ, value)
+	if err != nil {
+		return gmetric.Sample{}, err
+	}
+	if units == "-" {
+		units = ""
+	}
+	groups := s.Groups
+	if len(fields) == 5 {
+		groups = []string{fields[4]}
+	}
+
+	// Reuse the Metric as long as it is unchanged.
+	m := s.metrics[name]
+	if m == nil || m.ValueType != typed.ValueType || m.Units != units || !m.HasGroups(groups) {
+		m = typed
+		m.Name = name
+		m.Units = units
+		m.Groups = groups
+		m.Slope = gmetric.SlopeBoth
+		if s.metrics == nil {
+			s.metrics = make(map[string]*gmetric.Metric)
+		}
+		s.metrics[name] = m
+	}
+	return gmetric.Sample{Metric: m, Value: value}, nil
+}
+
+// Returns a Metric with the ValueType named by the type, after checking the
+// value is valid for it. Integers must fit the size of the type.
+func typedMetric(typ, value string) (*gmetric.Metric, error) {
+	m := &gmetric.Metric{}
+	var err error
+	switch typ {
+	case "string":
+		m.ValueType = gmetric.ValueString
+	case "int8":
+		m.ValueType = gmetric.ValueInt8
+		_, err = strconv.ParseInt(value, 10, 8)
+	case "uint8":
+		m.ValueType = gmetric.ValueUint8
+		_, err = strconv.ParseUint(value, 10, 8)
+	case "int16":
+		m.ValueType = gmetric.ValueInt16
+		_, err = strconv.ParseInt(value, 10, 16)
+	case "uint16":
+		m.ValueType = gmetric.ValueUint16
+		_, err = strconv.ParseUint(value, 10, 16)
+	case "int32":
+		m.ValueType = gmetric.ValueInt32
+		_, err = strconv.ParseInt(value, 10, 32)
+	case "uint32":
+		m.ValueType = gmetric.ValueUint32
+		_, err = strconv.ParseUint(value, 10, 32)
+	case "float":
+		m.ValueType = gmetric.ValueFloat32
+		_, err = strconv.ParseFloat(value, 32)
+	case "double":
+		m.ValueType = gmetric.ValueFloat64
+		_, err = strconv.ParseFloat(value, 64)
+	default:
+		return nil, errUnknownType
+	}
+	if err != nil {
+		return nil, fmt.Errorf("value is not a valid %s", typ)
+	}
+	return m, nil
+}
+
+// Splits the line on whitespace, allowing for double quoted fields.
+func split(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return fields, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexFunc(line, unicode.IsSpace)
+			if end < 0 {
+				end = len(line)
+			}
+			fields = append(fields, line[:end])
+			line = line[end:]
+			continue
+		}
+		end := 1
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(line) {
+			return nil, errUnclosedQuote
+		}
+		field, err := strconv.Unquote(line[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+		line = line[end+1:]
+	}
+}
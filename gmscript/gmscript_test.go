@@ -0,0 +1,182 @@
+package gmscript
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+func collect(t *testing.T, s *Script) (map[string]gmetric.Sample, error) {
+	samples, err := s.Collect()
+	byName := make(map[string]gmetric.Sample)
+	for _, sample := range samples {
+		byName[sample.Metric.Name] = sample
+	}
+	return byName, err
+}
+
+func checkValue(t *testing.T, samples map[string]gmetric.Sample, name string, expected interface{}) {
+	s, ok := samples[name]
+	if !ok {
+		t.Fatalf("missing sample for %s in %v", name, samples)
+	}
+	if fmt.Sprint(s.Value) != fmt.Sprint(expected) {
+		t.Fatalf("was expecting %v for %s but got %v", expected, name, s.Value)
+	}
+}
+
+func TestScript(t *testing.T) {
+	t.Parallel()
+	s := &Script{
+		Name:   "test",
+		Groups: []string{"default"},
+		Command: []string{"sh", "-c", `
+echo "# comment"
+echo "queue_depth 42 uint32 jobs queue"
+echo ""
+echo "disk_temp 36.5 float Celsius"
+echo 'raid_status "degraded array" string - "storage health"'
+echo "bad_value abc uint32 jobs"
+echo "bad_type 1 int64 jobs"
+echo "too few"
+echo "fraction 1.5 int8 jobs"
+echo "overflow 1e9 int8 jobs"
+echo "negative -1 uint16 jobs"
+exit 3
+`},
+	}
+	samples, err := collect(t, s)
+	if err == nil {
+		t.Fatal("was expecting an error")
+	}
+	for _, str := range []string{
+		"test failed: exit status 3",
+		"line 6: value is not a valid uint32",
+		"line 7: unknown type",
+		"line 8: too few fields",
+		"line 9: value is not a valid int8",
+		"line 10: value is not a valid int8",
+		"line 11: value is not a valid uint16",
+	} {
+		if !strings.Contains(err.Error(), str) {
+			t.Fatalf(`was expecting error with "%s" but got "%s"`, str, err)
+		}
+	}
+
+	checkValue(t, samples, "queue_depth", "42")
+	checkValue(t, samples, "disk_temp", "36.5")
+	checkValue(t, samples, "raid_status", "degraded array")
+	checkValue(t, samples, "script_test_exit_code", 3)
+	checkValue(t, samples, "script_test_malformed_lines", 6)
+	if len(samples) != 6 {
+		t.Fatalf("was expecting 6 samples but got %v", samples)
+	}
+
+	queue := samples["queue_depth"].Metric
+	if queue.ValueType != gmetric.ValueUint32 || queue.Units != "jobs" || !reflect.DeepEqual(queue.Groups, []string{"queue"}) {
+		t.Fatalf("unexpected metric %+v", queue)
+	}
+	temp := samples["disk_temp"].Metric
+	if temp.ValueType != gmetric.ValueFloat32 || !reflect.DeepEqual(temp.Groups, []string{"default"}) {
+		t.Fatalf("unexpected metric %+v", temp)
+	}
+	raid := samples["raid_status"].Metric
+	if raid.Units != "" || !reflect.DeepEqual(raid.Groups, []string{"storage health"}) {
+		t.Fatalf("unexpected metric %+v", raid)
+	}
+
+	// Unchanged metrics are reused.
+	again, _ := collect(t, s)
+	if again["queue_depth"].Metric != queue {
+		t.Fatal("was expecting the metric to be reused")
+	}
+}
+
+func TestScriptSuccess(t *testing.T) {
+	t.Parallel()
+	s := &Script{
+		Name:    "ok",
+		Prefix:  "cron",
+		Command: []string{"echo", "up 1 uint8 -"},
+	}
+	samples, err := collect(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, samples, "up", "1")
+	checkValue(t, samples, "cron_ok_exit_code", 0)
+	checkValue(t, samples, "cron_ok_malformed_lines", 0)
+	if _, ok := samples["cron_ok_duration"]; !ok {
+		t.Fatal("missing duration")
+	}
+}
+
+func TestScriptLongLine(t *testing.T) {
+	t.Parallel()
+	s := &Script{
+		Name:    "long",
+		Command: []string{"sh", "-c", "echo up 1 uint8 -; head -c 70000 /dev/zero | tr '\\0' x; echo"},
+	}
+	samples, err := collect(t, s)
+	if err == nil || !strings.Contains(err.Error(), "long output: bufio.Scanner: token too long") {
+		t.Fatalf("was expecting a token too long error but got %v", err)
+	}
+	checkValue(t, samples, "up", "1")
+	checkValue(t, samples, "script_long_exit_code", 0)
+}
+
+func TestScriptTimeout(t *testing.T) {
+	t.Parallel()
+	s := &Script{
+		Name:    "slow",
+		Command: []string{"sleep", "10"},
+		Timeout: 50 * time.Millisecond,
+	}
+	samples, err := collect(t, s)
+	if err == nil || !strings.Contains(err.Error(), "slow timed out after 50ms") {
+		t.Fatalf("was expecting a timeout error but got %v", err)
+	}
+	checkValue(t, samples, "script_slow_exit_code", -1)
+}
+
+func TestScriptNotFound(t *testing.T) {
+	t.Parallel()
+	s := &Script{Name: "missing", Command: []string{"/does/not/exist"}}
+	samples, err := collect(t, s)
+	if err == nil {
+		t.Fatal("was expecting an error")
+	}
+	checkValue(t, samples, "script_missing_exit_code", -1)
+}
+
+func TestScriptNoCommand(t *testing.T) {
+	t.Parallel()
+	s := &Script{Name: "empty"}
+	if _, err := s.Collect(); err != errNoCommand {
+		t.Fatalf("was expecting errNoCommand but got %v", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		line   string
+		fields []string
+		err    error
+	}{
+		{`a b  c`, []string{"a", "b", "c"}, nil},
+		{`a "b c" d`, []string{"a", "b c", "d"}, nil},
+		{`"a \"quoted\" value"`, []string{`a "quoted" value`}, nil},
+		{`a "b`, nil, errUnclosedQuote},
+	}
+	for _, c := range cases {
+		fields, err := split(c.line)
+		if err != c.err || !reflect.DeepEqual(fields, c.fields) {
+			t.Fatalf("for %q was expecting %q, %v but got %q, %v", c.line, c.fields, c.err, fields, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package gmsql publishes database/sql connection pool statistics to ganglia.
+package gmsql
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+// Collector is a gmetric.Collector that provides the connection pool
+// statistics for a set of named *sql.DB handles. The metrics for each pool
+// are published in their own group named "<prefix>_<name>". The cumulative
+// statistics, such as the wait count and duration, are published as rates
+// and are omitted on the first collection.
+type Collector struct {
+	// Prefix for the metric names. Defaults to "sql".
+	Prefix string
+
+	mu    sync.Mutex
+	now   func() time.Time
+	pools []*pool
+}
+
+var _ gmetric.Collector = (*Collector)(nil)
+
+type pool struct {
+	name   string
+	db     *sql.DB
+	last   sql.DBStats
+	lastAt time.Time
+
+	maxOpen           *gmetric.Metric
+	open              *gmetric.Metric
+	inUse             *gmetric.Metric
+	idle              *gmetric.Metric
+	waits             *gmetric.Metric
+	waitTime          *gmetric.Metric
+	maxIdleClosed     *gmetric.Metric
+	maxIdleTimeClosed *gmetric.Metric
+	maxLifetimeClosed *gmetric.Metric
+}
+
+// Add a named pool. The name becomes part of the metric names and should
+// only contain characters safe for a file name. Adding a name again replaces
+// the pool.
+func (c *Collector) Add(name string, db *sql.DB) {
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = "sql"
+	}
+	base := prefix + "_" + name
+	groups := []string{base}
+	metric := func(suffix, title, units string) *gmetric.Metric {
+		return &gmetric.Metric{
+			Name:      base + "_" + suffix,
+			Title:     title + " (" + name + ")",
+			Units:     units,
+			Groups:    groups,
+			ValueType: gmetric.ValueUint32,
+			Slope:     gmetric.SlopeBoth,
+		}
+	}
+	rate := func(suffix, title, units string) *gmetric.Metric {
+		m := metric(suffix, title, units)
+		m.ValueType = gmetric.ValueFloat64
+		return m
+	}
+
+	p := &pool{
+		name:              name,
+		db:                db,
+		maxOpen:           metric("max_open", "Max Open Connections", "connections"),
+		open:              metric("open", "Open Connections", "connections"),
+		inUse:             metric("in_use", "In Use Connections", "connections"),
+		idle:              metric("idle", "Idle Connections", "connections"),
+		waits:             rate("waits", "Connection Waits", "waits/sec"),
+		waitTime:          rate("wait_time", "Connection Wait Time", "ms/sec"),
+		maxIdleClosed:     rate("max_idle_closed", "Closed by Max Idle", "connections/sec"),
+		maxIdleTimeClosed: rate("max_idle_time_closed", "Closed by Max Idle Time", "connections/sec"),
+		maxLifetimeClosed: rate("max_lifetime_closed", "Closed by Max Lifetime", "connections/sec"),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, existing := range c.pools {
+		if existing.name == name {
+			c.pools[i] = p
+			return
+		}
+	}
+	c.pools = append(c.pools, p)
+	sort.Sort(byName(c.pools))
+}
+
+// Metrics returns the metrics for the pools added so far.
+func (c *Collector) Metrics() []*gmetric.Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var metrics []*gmetric.Metric
+	for _, p := range c.pools {
+		metrics = append(metrics,
+			p.maxOpen, p.open, p.inUse, p.idle, p.waits, p.waitTime,
+			p.maxIdleClosed, p.maxIdleTimeClosed, p.maxLifetimeClosed,
+		)
+	}
+	return metrics
+}
+
+// Collect returns the current statistics for each of the pools.
+func (c *Collector) Collect() ([]gmetric.Sample, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+
+	var samples []gmetric.Sample
+	for _, p := range c.pools {
+		at := now()
+		s := p.db.Stats()
+		samples = append(samples,
+			gmetric.Sample{Metric: p.maxOpen, Value: s.MaxOpenConnections},
+			gmetric.Sample{Metric: p.open, Value: s.OpenConnections},
+			gmetric.Sample{Metric: p.inUse, Value: s.InUse},
+			gmetric.Sample{Metric: p.idle, Value: s.Idle},
+		)
+
+		last, lastAt := p.last, p.lastAt
+		p.last, p.lastAt = s, at
+		if lastAt.IsZero() {
+			continue
+		}
+		elapsed := at.Sub(lastAt).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		rates := []struct {
+			metric    *gmetric.Metric
+			cur, last int64
+		}{
+			{p.waits, s.WaitCount, last.WaitCount},
+			{p.maxIdleClosed, s.MaxIdleClosed, last.MaxIdleClosed},
+			{p.maxIdleTimeClosed, s.MaxIdleTimeClosed, last.MaxIdleTimeClosed},
+			{p.maxLifetimeClosed, s.MaxLifetimeClosed, last.MaxLifetimeClosed},
+		}
+		for _, r := range rates {
+			samples = append(samples, gmetric.Sample{
+				Metric: r.metric,
+				Value:  float64(r.cur-r.last) / elapsed,
+			})
+		}
+		waited := s.WaitDuration - last.WaitDuration
+		samples = append(samples, gmetric.Sample{
+			Metric: p.waitTime,
+			Value:  waited.Seconds() * 1000 / elapsed,
+		})
+	}
+	return samples, nil
+}
+
+type byName []*pool
+
+func (p byName) Len() int           { return len(p) }
+func (p byName) Less(i, j int) bool { return p[i].name < p[j].name }
+func (p byName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
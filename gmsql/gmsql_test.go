@@ -0,0 +1,122 @@
+package gmsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var errNotSupported = errors.New("not supported")
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errNotSupported }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errNotSupported }
+
+func init() {
+	sql.Register("gmsql_fake", fakeDriver{})
+}
+
+func collect(t *testing.T, c *Collector) map[string]interface{} {
+	samples, err := c.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := make(map[string]interface{})
+	for _, s := range samples {
+		v[s.Metric.Name] = s.Value
+	}
+	return v
+}
+
+func checkValue(t *testing.T, values map[string]interface{}, name string, expected interface{}) {
+	actual, ok := values[name]
+	if !ok {
+		t.Fatalf("missing value for %s in %v", name, values)
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("was expecting %v for %s but got %v", expected, name, actual)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+	db, err := sql.Open("gmsql_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	at := time.Unix(1500000000, 0)
+	c := &Collector{now: func() time.Time { return at }}
+	c.Add("users", db)
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := collect(t, c)
+	checkValue(t, v, "sql_users_max_open", 1)
+	checkValue(t, v, "sql_users_open", 1)
+	checkValue(t, v, "sql_users_in_use", 1)
+	checkValue(t, v, "sql_users_idle", 0)
+	if _, ok := v["sql_users_waits"]; ok {
+		t.Fatal("was not expecting rates on the first collection")
+	}
+
+	// With the only connection in use, this will wait until it times out.
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.Conn(waitCtx); err == nil {
+		t.Fatal("was expecting a timeout")
+	}
+	conn.Close()
+
+	at = at.Add(time.Second)
+	v = collect(t, c)
+	checkValue(t, v, "sql_users_in_use", 0)
+	checkValue(t, v, "sql_users_idle", 1)
+	checkValue(t, v, "sql_users_waits", 1)
+	checkValue(t, v, "sql_users_max_lifetime_closed", 0)
+	if wait := v["sql_users_wait_time"].(float64); wait < 10 {
+		t.Fatalf("was expecting at least 10ms/sec of wait time but got %v", wait)
+	}
+}
+
+func TestCollectorMetrics(t *testing.T) {
+	t.Parallel()
+	db, err := sql.Open("gmsql_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := &Collector{Prefix: "db"}
+	c.Add("b", db)
+	c.Add("a", db)
+	c.Add("a", db)
+	metrics := c.Metrics()
+	if len(metrics) != 18 {
+		t.Fatalf("was expecting 18 metrics but got %d", len(metrics))
+	}
+	if metrics[0].Name != "db_a_max_open" || metrics[0].Groups[0] != "db_a" {
+		t.Fatalf("unexpected first metric %+v", metrics[0])
+	}
+	if metrics[9].Name != "db_b_max_open" {
+		t.Fatalf("unexpected metric %+v", metrics[9])
+	}
+}
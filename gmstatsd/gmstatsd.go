@@ -0,0 +1,569 @@
+// Package gmstatsd implements a StatsD server that aggregates the received
+// metrics and publishes them to ganglia.
+package gmstatsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/ganglia/gmetric"
+)
+
+var (
+	errAlreadyServing = errors.New("gmstatsd: server is already serving")
+	errInvalidLine    = errors.New("gmstatsd: invalid line")
+	errInvalidValue   = errors.New("gmstatsd: invalid value")
+	errInvalidType    = errors.New("gmstatsd: invalid type")
+	errInvalidRate    = errors.New("gmstatsd: invalid sample rate")
+)
+
+// The default address, the usual StatsD port.
+const defaultAddr = ":8125"
+
+// The default percentiles published for timers.
+var defaultPercentiles = []float64{50, 95, 99}
+
+// The largest UDP payload.
+const maxPacketSize = 65535
+
+// The default time after which series without new values are dropped.
+const defaultIdleTimeout = 10 * time.Minute
+
+// Server receives StatsD metrics over UDP and is a gmetric.Collector
+// providing the aggregated values, which are reset on every call to Collect.
+// Add it to a gmetric.Scheduler with the desired flush interval.
+//
+// The metrics of each type are published in their own namespace, like StatsD
+// does, so the same name used with different types does not collide.
+// Counters ("c") publish "counters.<name>.rate", the rate per second, and
+// "counters.<name>.count", the total in the interval. Gauges ("g") publish
+// "gauges.<name>" and keep their value across intervals, and a leading sign
+// on the value adjusts the current value. Timers ("ms" or "h") publish
+// "timers.<name>.count", "timers.<name>.rate", "timers.<name>.mean",
+// "timers.<name>.min", "timers.<name>.max" and the percentiles such as
+// "timers.<name>.p99", in milliseconds. Sets ("s") publish "sets.<name>",
+// the number of unique values in the interval. Sample rates such as "|@0.1"
+// scale counters and the counts of timers. Series that receive no values for
+// the IdleTimeout are dropped.
+type Server struct {
+	// The UDP address to listen on. Defaults to ":8125".
+	Addr string
+
+	// Prefix for the metric names.
+	Prefix string
+
+	// The groups for the metrics.
+	Groups []string
+
+	// The timer percentiles to publish. Defaults to 50, 95 and 99.
+	Percentiles []float64
+
+	// The number of values kept per interval and timer to derive the
+	// percentiles. Defaults to 1024.
+	ReservoirSize int
+
+	// Series that receive no values for this long are no longer published
+	// and forgotten, so names sent once do not accumulate. Defaults to 10
+	// minutes, a negative value keeps series forever.
+	IdleTimeout time.Duration
+
+	// Optional handler for network errors and invalid lines.
+	ErrorHandler func(error)
+
+	mu       sync.Mutex
+	now      func() time.Time
+	since    time.Time
+	conn     net.PacketConn
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+	sets     map[string]*set
+	invalid  uint64
+	self     *gmetric.Metric
+}
+
+var _ gmetric.Collector = (*Server)(nil)
+
+type counter struct {
+	rate, count *gmetric.Metric
+	value       float64
+	last        time.Time
+}
+
+type gauge struct {
+	metric *gmetric.Metric
+	value  float64
+	last   time.Time
+}
+
+type timer struct {
+	count, rate, mean, min, max *gmetric.Metric
+	percentiles                 []*gmetric.Metric
+
+	seen   uint64
+	scaled float64
+	sum    float64
+	lo, hi float64
+	values gmetric.Reservoir
+	last   time.Time
+}
+
+type set struct {
+	metric *gmetric.Metric
+	values map[string]struct{}
+	last   time.Time
+}
+
+// ListenAndServe listens on the UDP address and serves until Close is
+// called.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve reads packets from the connection until Close is called. The
+// connection is closed when Serve returns.
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.conn != nil {
+		s.mu.Unlock()
+		conn.Close()
+		return errAlreadyServing
+	}
+	s.init()
+	s.conn = conn
+	s.mu.Unlock()
+
+	defer conn.Close()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if n > 0 {
+			s.handle(buf[:n])
+		}
+		if err != nil {
+			s.mu.Lock()
+			closed := s.conn == nil
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.error(err)
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func (s *Server) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *Server) init() {
+	if s.self != nil {
+		return
+	}
+	s.since = s.clock()
+	s.counters = make(map[string]*counter)
+	s.gauges = make(map[string]*gauge)
+	s.timers = make(map[string]*timer)
+	s.sets = make(map[string]*set)
+	s.self = &gmetric.Metric{
+		Name:        s.Prefix + "statsd_invalid_lines",
+		Title:       "Invalid StatsD Lines",
+		Description: "The number of invalid lines received in the interval",
+		Units:       "lines",
+		Groups:      s.Groups,
+		ValueType:   gmetric.ValueUint32,
+		Slope:       gmetric.SlopeBoth,
+	}
+}
+
+// Handles a packet of newline separated lines.
+func (s *Server) handle(packet []byte) {
+	var errs []error
+	s.mu.Lock()
+	s.init()
+	now := s.clock()
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := s.handleLine(string(line), now); err != nil {
+			s.invalid++
+			errs = append(errs, fmt.Errorf("%s: %q", err, line))
+		}
+	}
+	s.mu.Unlock()
+
+	// The handler is called without holding the lock.
+	for _, err := range errs {
+		s.error(err)
+	}
+}
+
+// Handles a line in the form "<name>:<value>|<type>[|@<rate>]". Multiple
+// values for the same name may be separated by a colon.
+func (s *Server) handleLine(line string, now time.Time) error {
+	i := strings.IndexByte(line, ':')
+	if i <= 0 {
+		return errInvalidLine
+	}
+	name := sanitize(line[:i])
+	if name == "" {
+		return errInvalidLine
+	}
+	var parts []string
+	for _, part := range strings.Split(line[i+1:], ":") {
+		// A colon without a following type is part of a tag, not a value.
+		if len(parts) > 0 && !strings.Contains(part, "|") {
+			parts[len(parts)-1] += ":" + part
+			continue
+		}
+		parts = append(parts, part)
+	}
+	for _, part := range parts {
+		fields := strings.Split(part, "|")
+		if len(fields) < 2 {
+			return errInvalidLine
+		}
+		rate := 1.0
+		for _, f := range fields[2:] {
+			// Other fields, such as tags, are ignored.
+			if strings.HasPrefix(f, "@") {
+				r, err := strconv.ParseFloat(f[1:], 64)
+				if err != nil || r <= 0 || r > 1 {
+					return errInvalidRate
+				}
+				rate = r
+			}
+		}
+		if err := s.record(name, fields[0], fields[1], rate, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) record(name, value, typ string, rate float64, now time.Time) error {
+	if typ == "s" {
+		set := s.set(name)
+		set.values[value] = struct{}{}
+		set.last = now
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return errInvalidValue
+	}
+	switch typ {
+	case "c":
+		c := s.counter(name)
+		c.value += v / rate
+		c.last = now
+	case "g":
+		g := s.gauge(name)
+		if value[0] == '+' || value[0] == '-' {
+			g.value += v
+		} else {
+			g.value = v
+		}
+		g.last = now
+	case "ms", "h":
+		t := s.timer(name)
+		t.last = now
+		t.seen++
+		t.scaled += 1 / rate
+		t.sum += v
+		if t.seen == 1 || v < t.lo {
+			t.lo = v
+		}
+		if t.seen == 1 || v > t.hi {
+			t.hi = v
+		}
+		t.values.Size = s.ReservoirSize
+		t.values.Observe(v)
+	default:
+		return errInvalidType
+	}
+	return nil
+}
+
+func (s *Server) metric(name, title, units string) *gmetric.Metric {
+	return &gmetric.Metric{
+		Name:      s.Prefix + name,
+		Title:     title,
+		Units:     units,
+		Groups:    s.Groups,
+		ValueType: gmetric.ValueFloat64,
+		Slope:     gmetric.SlopeBoth,
+	}
+}
+
+func (s *Server) counter(name string) *counter {
+	c := s.counters[name]
+	if c == nil {
+		base := "counters." + name
+		c = &counter{
+			rate:  s.metric(base+".rate", name+" Rate", "/sec"),
+			count: s.metric(base+".count", name+" Count", "count"),
+		}
+		s.counters[name] = c
+	}
+	return c
+}
+
+func (s *Server) gauge(name string) *gauge {
+	g := s.gauges[name]
+	if g == nil {
+		g = &gauge{metric: s.metric("gauges."+name, name, "")}
+		s.gauges[name] = g
+	}
+	return g
+}
+
+func (s *Server) timer(name string) *timer {
+	t := s.timers[name]
+	if t == nil {
+		base := "timers." + name
+		t = &timer{
+			count: s.metric(base+".count", name+" Count", "count"),
+			rate:  s.metric(base+".rate", name+" Rate", "/sec"),
+			mean:  s.metric(base+".mean", name+" Mean", "ms"),
+			min:   s.metric(base+".min", name+" Min", "ms"),
+			max:   s.metric(base+".max", name+" Max", "ms"),
+		}
+		for _, p := range s.percentiles() {
+			suffix := gmetric.PercentileSuffix(p)
+			t.percentiles = append(
+				t.percentiles,
+				s.metric(base+"."+suffix, name+" "+suffix, "ms"),
+			)
+		}
+		s.timers[name] = t
+	}
+	return t
+}
+
+func (s *Server) set(name string) *set {
+	st := s.sets[name]
+	if st == nil {
+		st = &set{
+			metric: s.metric("sets."+name, name, "values"),
+			values: make(map[string]struct{}),
+		}
+		st.metric.ValueType = gmetric.ValueUint32
+		s.sets[name] = st
+	}
+	return st
+}
+
+func (s *Server) percentiles() []float64 {
+	if len(s.Percentiles) == 0 {
+		return defaultPercentiles
+	}
+	return s.Percentiles
+}
+
+// Metrics returns the metrics received so far.
+func (s *Server) Metrics() []*gmetric.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	metrics := []*gmetric.Metric{s.self}
+	for _, name := range sortedNames(s.counters) {
+		c := s.counters[name]
+		metrics = append(metrics, c.rate, c.count)
+	}
+	for _, name := range sortedNames(s.gauges) {
+		metrics = append(metrics, s.gauges[name].metric)
+	}
+	for _, name := range sortedNames(s.timers) {
+		t := s.timers[name]
+		metrics = append(metrics, t.count, t.rate, t.mean, t.min, t.max)
+		metrics = append(metrics, t.percentiles...)
+	}
+	for _, name := range sortedNames(s.sets) {
+		metrics = append(metrics, s.sets[name].metric)
+	}
+	return metrics
+}
+
+// Collect returns the values aggregated since the last call to Collect and
+// resets the counters, timers and sets. Idle series are dropped after their
+// values are returned.
+func (s *Server) Collect() ([]gmetric.Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	now := s.clock()
+	elapsed := now.Sub(s.since).Seconds()
+	s.since = now
+
+	samples := []gmetric.Sample{{Metric: s.self, Value: s.invalid}}
+	s.invalid = 0
+
+	for _, name := range sortedNames(s.counters) {
+		c := s.counters[name]
+		if elapsed > 0 {
+			samples = append(samples, gmetric.Sample{Metric: c.rate, Value: c.value / elapsed})
+		}
+		samples = append(samples, gmetric.Sample{Metric: c.count, Value: c.value})
+		c.value = 0
+	}
+	for _, name := range sortedNames(s.gauges) {
+		g := s.gauges[name]
+		samples = append(samples, gmetric.Sample{Metric: g.metric, Value: g.value})
+	}
+	for _, name := range sortedNames(s.timers) {
+		samples = append(samples, s.timerSamples(s.timers[name], elapsed)...)
+	}
+	for _, name := range sortedNames(s.sets) {
+		st := s.sets[name]
+		samples = append(samples, gmetric.Sample{Metric: st.metric, Value: len(st.values)})
+		st.values = make(map[string]struct{})
+	}
+	s.expire(now)
+	return samples, nil
+}
+
+// Drops the series that received no values for the IdleTimeout.
+func (s *Server) expire(now time.Time) {
+	idle := s.IdleTimeout
+	if idle == 0 {
+		idle = defaultIdleTimeout
+	}
+	if idle < 0 {
+		return
+	}
+	for name, c := range s.counters {
+		if now.Sub(c.last) >= idle {
+			delete(s.counters, name)
+		}
+	}
+	for name, g := range s.gauges {
+		if now.Sub(g.last) >= idle {
+			delete(s.gauges, name)
+		}
+	}
+	for name, t := range s.timers {
+		if now.Sub(t.last) >= idle {
+			delete(s.timers, name)
+		}
+	}
+	for name, st := range s.sets {
+		if now.Sub(st.last) >= idle {
+			delete(s.sets, name)
+		}
+	}
+}
+
+func (s *Server) timerSamples(t *timer, elapsed float64) []gmetric.Sample {
+	samples := []gmetric.Sample{{Metric: t.count, Value: t.scaled}}
+	if elapsed > 0 {
+		samples = append(samples, gmetric.Sample{Metric: t.rate, Value: t.scaled / elapsed})
+	}
+	if t.seen > 0 {
+		samples = append(
+			samples,
+			gmetric.Sample{Metric: t.mean, Value: t.sum / float64(t.seen)},
+			gmetric.Sample{Metric: t.min, Value: t.lo},
+			gmetric.Sample{Metric: t.max, Value: t.hi},
+		)
+		for i, p := range s.percentiles() {
+			if i >= len(t.percentiles) {
+				break
+			}
+			samples = append(samples, gmetric.Sample{
+				Metric: t.percentiles[i],
+				Value:  t.values.Percentile(p),
+			})
+		}
+	}
+	t.seen, t.scaled, t.sum, t.lo, t.hi = 0, 0, 0, 0, 0
+	t.values.Reset()
+	return samples
+}
+
+func (s *Server) error(err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+}
+
+// Cleans up a name the same way StatsD does: whitespace becomes an
+// underscore, a slash becomes a dash and other unsafe characters are
+// removed.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ' || r == '\t':
+			return '_'
+		case r == '/':
+			return '-'
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '_' || r == '-' || r == '.':
+			return r
+		}
+		return -1
+	}, name)
+}
+
+func sortedNames(m interface{}) []string {
+	var names []string
+	switch m := m.(type) {
+	case map[string]*counter:
+		for name := range m {
+			names = append(names, name)
+		}
+	case map[string]*gauge:
+		for name := range m {
+			names = append(names, name)
+		}
+	case map[string]*timer:
+		for name := range m {
+			names = append(names, name)
+		}
+	case map[string]*set:
+		for name := range m {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
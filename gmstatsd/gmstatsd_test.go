@@ -0,0 +1,215 @@
+package gmstatsd
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, s *Server) map[string]interface{} {
+	samples, err := s.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{})
+	for _, smp := range samples {
+		if _, ok := values[smp.Metric.Name]; ok {
+			t.Fatalf("duplicate sample for %s", smp.Metric.Name)
+		}
+		values[smp.Metric.Name] = smp.Value
+	}
+	return values
+}
+
+func checkValue(t *testing.T, values map[string]interface{}, name string, expected float64) {
+	actual, ok := values[name]
+	if !ok {
+		t.Fatalf("missing value for %s", name)
+	}
+	var f float64
+	switch v := actual.(type) {
+	case float64:
+		f = v
+	case uint64:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	default:
+		t.Fatalf("unexpected type %T for %s", actual, name)
+	}
+	if math.Abs(f-expected) > 1e-9 {
+		t.Fatalf("was expecting %v for %s but got %v", expected, name, f)
+	}
+}
+
+func checkMissing(t *testing.T, values map[string]interface{}, name string) {
+	if v, ok := values[name]; ok {
+		t.Fatalf("was not expecting a value for %s but got %v", name, v)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	t.Parallel()
+	at := time.Unix(1500000000, 0)
+	s := &Server{
+		Prefix: "app.",
+		now:    func() time.Time { return at },
+	}
+	s.handle([]byte("hits:1|c\nhits:2|c|@0.5\nhits:1|c:3|c\n" +
+		"temp:20|g\ntemp:+5|g\ntemp:-1|g\n" +
+		"rt:10|ms\nrt:30|ms\nrt:20|ms|@0.5\n" +
+		"users:a|s\nusers:b|s\nusers:a|s\n" +
+		"my key/x:1|c\n"))
+
+	at = at.Add(10 * time.Second)
+	values := collect(t, s)
+	checkValue(t, values, "app.counters.hits.rate", 0.9)
+	checkValue(t, values, "app.counters.hits.count", 9)
+	checkValue(t, values, "app.gauges.temp", 24)
+	checkValue(t, values, "app.timers.rt.count", 4)
+	checkValue(t, values, "app.timers.rt.rate", 0.4)
+	checkValue(t, values, "app.timers.rt.mean", 20)
+	checkValue(t, values, "app.timers.rt.min", 10)
+	checkValue(t, values, "app.timers.rt.max", 30)
+	checkValue(t, values, "app.timers.rt.p50", 20)
+	checkValue(t, values, "app.timers.rt.p99", 30)
+	checkValue(t, values, "app.sets.users", 2)
+	checkValue(t, values, "app.counters.my_key-x.count", 1)
+	checkValue(t, values, "app.statsd_invalid_lines", 0)
+
+	// Counters, timers and sets are reset, gauges are kept.
+	at = at.Add(10 * time.Second)
+	values = collect(t, s)
+	checkValue(t, values, "app.counters.hits.rate", 0)
+	checkValue(t, values, "app.gauges.temp", 24)
+	checkValue(t, values, "app.timers.rt.count", 0)
+	checkMissing(t, values, "app.timers.rt.mean")
+	checkValue(t, values, "app.sets.users", 0)
+}
+
+func TestInvalidLines(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var errs []error
+	s := &Server{
+		ErrorHandler: func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	}
+	s.handle([]byte("nocolon\n:1|c\na:1\na:x|c\na:1|q\na:1|c|@2\na:1|c|#tag:x\n"))
+	values := collect(t, s)
+	checkValue(t, values, "statsd_invalid_lines", 6)
+	checkValue(t, values, "counters.a.count", 1)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 6 {
+		t.Fatalf("was expecting 6 errors but got %d", len(errs))
+	}
+	if !strings.Contains(errs[0].Error(), "nocolon") {
+		t.Fatalf("was expecting the line in the error but got %s", errs[0])
+	}
+}
+
+func TestMetricsTypes(t *testing.T) {
+	t.Parallel()
+	s := &Server{Groups: []string{"statsd"}}
+	s.handle([]byte("a:1|c\nb:1|g\nc:1|ms\nd:x|s\n"))
+	names := make(map[string]bool)
+	for _, m := range s.Metrics() {
+		names[m.Name] = true
+		if len(m.Groups) != 1 || m.Groups[0] != "statsd" {
+			t.Fatalf("was expecting the statsd group for %s but got %v", m.Name, m.Groups)
+		}
+	}
+	for _, name := range []string{"counters.a.rate", "counters.a.count", "gauges.b", "timers.c.p95", "sets.d", "statsd_invalid_lines"} {
+		if !names[name] {
+			t.Fatalf("missing metric %s", name)
+		}
+	}
+}
+
+func TestTypesDoNotCollide(t *testing.T) {
+	t.Parallel()
+	s := &Server{}
+	s.handle([]byte("x:1|c\nx:2|g\nx:3|ms\nx:a|s\n"))
+	values := collect(t, s)
+	checkValue(t, values, "counters.x.count", 1)
+	checkValue(t, values, "gauges.x", 2)
+	checkValue(t, values, "timers.x.count", 1)
+	checkValue(t, values, "sets.x", 1)
+}
+
+func TestIdleSeriesExpire(t *testing.T) {
+	t.Parallel()
+	at := time.Unix(1500000000, 0)
+	s := &Server{
+		IdleTimeout: time.Minute,
+		now:         func() time.Time { return at },
+	}
+	s.handle([]byte("a:1|c\nb:1|g\nc:1|ms\nd:x|s\n"))
+	at = at.Add(30 * time.Second)
+	s.handle([]byte("b:2|g\n"))
+	collect(t, s)
+
+	// The series are published one last time once they are idle for a
+	// minute, and then dropped.
+	at = at.Add(30 * time.Second)
+	values := collect(t, s)
+	checkValue(t, values, "counters.a.count", 0)
+	checkValue(t, values, "gauges.b", 2)
+
+	at = at.Add(30 * time.Second)
+	values = collect(t, s)
+	for _, name := range []string{"counters.a.count", "timers.c.count", "sets.d"} {
+		checkMissing(t, values, name)
+	}
+	checkValue(t, values, "gauges.b", 2)
+
+	at = at.Add(30 * time.Second)
+	checkMissing(t, collect(t, s), "gauges.b")
+	if len(s.Metrics()) != 1 {
+		t.Fatalf("was expecting only the invalid lines metric but got %d", len(s.Metrics()))
+	}
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var total float64
+	for total == 0 && time.Now().Before(deadline) {
+		if _, err := client.Write([]byte("hits:1|c")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		total, _ = collect(t, s)["counters.hits.count"].(float64)
+	}
+	if total == 0 {
+		t.Fatal("was expecting to receive a packet")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("was expecting no error from Serve but got %s", err)
+	}
+}